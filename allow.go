@@ -0,0 +1,170 @@
+package fcheck
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//Allowlist holds expected paths and their known-good content, new and changed files matching them are reported
+//as expected (see Finding.Expected) instead of as alerts, e.g. caches packages keep regenerating
+type Allowlist struct {
+	rules []*allowRule
+}
+
+//allowRule expects the files at its selector, if they have all of the attributes given
+type allowRule struct {
+	label   string
+	sel     *matchRule
+	perm    *uint32
+	uid     *uint32
+	gid     *uint32
+	digests *HashList
+}
+
+//NewAllowlist returns new empty Allowlist
+func NewAllowlist() *Allowlist {
+	return &Allowlist{}
+}
+
+//Len returns the number of rules in the allowlist
+func (a *Allowlist) Len() int {
+	return len(a.rules)
+}
+
+//Load adds the entries in the file fname, see Read
+func (a *Allowlist) Load(fname string) (int, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return a.Read(f, filepath.Base(fname))
+}
+
+//Read adds the rules read from r and returns how many were read, name is used in labels and errors
+//a rule is [<checksum>] [perm=<octal>] [uid=<id>] [gid=<id>] [digest=<checksum>] <selector>, expecting the files
+//at the selector (as in exclude rules) to have all of the attributes given, a leading checksum (md5, sha1, sha256
+//or sha512) is the same as digest=, blank lines and those starting with # are skipped
+func (a *Allowlist) Read(r io.Reader, name string) (int, error) {
+	sc := bufio.NewScanner(r)
+	n := 0
+	for lineno := 1; sc.Scan(); lineno++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if d, err := hex.DecodeString(fields[0]); err == nil && iocHashes[len(d)] != nil {
+			fields[0] = "digest=" + fields[0]
+		}
+		rule, err := parseAllowRule(fields)
+		if err != nil {
+			return n, fmt.Errorf("%s:%d: %s", name, lineno, err)
+		}
+		rule.label = fmt.Sprintf("%s:%d", name, lineno)
+		a.rules = append(a.rules, rule)
+		n++
+	}
+	return n, sc.Err()
+}
+
+//parseAllowRule parses the fields of [perm=<octal>] [uid=<id>] [gid=<id>] [digest=<checksum>] <selector>
+func parseAllowRule(fields []string) (*allowRule, error) {
+	r := &allowRule{}
+	id := func(f string) (*uint32, error) {
+		v, err := strconv.ParseUint(f[strings.IndexByte(f, '=')+1:], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s", f)
+		}
+		u := uint32(v)
+		return &u, nil
+	}
+	for len(fields) > 0 {
+		f := fields[0]
+		var err error
+		switch {
+		case strings.HasPrefix(f, "perm="):
+			v, perr := strconv.ParseUint(strings.TrimPrefix(f, "perm="), 8, 32)
+			if perr != nil || v > 07777 {
+				return nil, fmt.Errorf("bad %s", f)
+			}
+			perm := uint32(v)
+			r.perm = &perm
+		case strings.HasPrefix(f, "uid="):
+			r.uid, err = id(f)
+		case strings.HasPrefix(f, "gid="):
+			r.gid, err = id(f)
+		case strings.HasPrefix(f, "digest="):
+			d, derr := hex.DecodeString(strings.TrimPrefix(f, "digest="))
+			if derr != nil {
+				return nil, fmt.Errorf("bad %s", f)
+			}
+			if r.digests == nil {
+				r.digests = NewHashList()
+			}
+			err = r.digests.Add(d, "")
+		default:
+			//the rest is the selector
+			r.sel, err = parseRule(strings.Join(fields, " "))
+			if err != nil {
+				return nil, err
+			}
+			if r.sel.include {
+				return nil, fmt.Errorf("! selectors are not supported")
+			}
+			return r, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields = fields[1:]
+	}
+	return nil, fmt.Errorf("missing selector")
+}
+
+//Match returns why f is expected, if it is a new, copied or changed file matching a rule
+//checksums the record of the file does not have are calculated reading it unless read is false
+func (a *Allowlist) Match(f *Finding, read bool) (string, bool) {
+	if a == nil || f.New == nil {
+		return "", false
+	}
+	switch f.Kind {
+	case KindNew, KindCopied, KindChanged:
+	default:
+		return "", false
+	}
+	path := filepath.Clean(f.Path)
+	parts := globParts(path)
+	for i := len(a.rules) - 1; i >= 0; i-- {
+		if r := a.rules[i]; r.sel.match(path, parts, f.New.Mode) && r.has(f, read) {
+			return r.label, true
+		}
+	}
+	return "", false
+}
+
+//has returns true if the file of f has all of the attributes of r
+//gaining the setuid or setgid bit is only expected by a rule whose perm has it
+func (r *allowRule) has(f *Finding, read bool) bool {
+	fc := f.New
+	if gainedSetuid(f) && (r.perm == nil || *r.perm&06000 == 0) {
+		return false
+	}
+	if r.perm != nil && unixPerm(fc.Mode) != *r.perm {
+		return false
+	}
+	if r.uid != nil && fc.Uid != *r.uid || r.gid != nil && fc.Gid != *r.gid {
+		return false
+	}
+	if r.digests != nil {
+		_, ok, _ := r.digests.Match(fc, read)
+		return ok
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package fcheck
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type AllowSuite struct {
+	scratch
+}
+
+var _ = Suite(&AllowSuite{})
+
+func (s *AllowSuite) SetUpTest(c *C) {
+	s.setUp(c)
+}
+
+func (s *AllowSuite) TestRead(c *C) {
+	a := NewAllowlist()
+	n, err := a.Read(strings.NewReader(fmt.Sprintf("# known good\n%x uid=0 /opt/vendor/blob\n\nperm=0644 uid=0 /usr/lib/python3/**/__pycache__/*\n", sha256.Sum256([]byte("pyc")))), "allow.txt")
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+	c.Assert(a.Len(), Equals, 2)
+	c.Assert(a.rules[0].digests.Len(), Equals, 1)
+	c.Assert(*a.rules[0].uid, Equals, uint32(0))
+	c.Assert(a.rules[0].label, Equals, "allow.txt:2")
+	c.Assert(*a.rules[1].perm, Equals, uint32(0644))
+	c.Assert(a.rules[1].label, Equals, "allow.txt:4")
+
+	_, err = a.Read(strings.NewReader("perm=999 /x\n"), "bad.txt")
+	c.Assert(err, ErrorMatches, `bad.txt:1: bad perm=999`)
+	_, err = a.Read(strings.NewReader("uid=0\n"), "bad.txt")
+	c.Assert(err, ErrorMatches, `bad.txt:1: missing selector`)
+	//known-good content is only expected where it belongs
+	_, err = a.Read(strings.NewReader(fmt.Sprintf("%x\n", sha256.Sum256([]byte("pyc")))), "bad.txt")
+	c.Assert(err, ErrorMatches, `bad.txt:1: missing selector`)
+	_, err = a.Read(strings.NewReader("!/x\n"), "bad.txt")
+	c.Assert(err, ErrorMatches, `bad.txt:1: ! selectors are not supported`)
+}
+
+func (s *AllowSuite) TestCheck(c *C) {
+	root := s.root
+	cache := filepath.Join(root, "__pycache__")
+	c.Assert(os.Mkdir(cache, 0755), IsNil)
+	s.generate(c)
+
+	s.write(c, "__pycache__/mod.pyc", "pyc")
+	s.writeMode(c, "__pycache__/odd.pyc", "odd", 0755)
+	s.writeMode(c, "known", "known", 0600)
+	//known-good content at an unexpected path, and gaining the setuid bit
+	s.writeMode(c, "misplaced", "known", 0600)
+	s.writeMode(c, "tool", "tool", 0755)
+	c.Assert(os.Chmod(filepath.Join(root, "tool"), os.ModeSetuid|0755), IsNil)
+	a := NewAllowlist()
+	_, err := a.Read(strings.NewReader(fmt.Sprintf("%x %s\nperm=0644 %s/*.pyc\n%s\n", sha256.Sum256([]byte("known")), filepath.Join(root, "known"), cache, filepath.Join(root, "tool"))), "allow.txt")
+	c.Assert(err, IsNil)
+
+	cm, buf := s.compare(c, func(cm *Comparator) {
+		cm.SetAllowlist(a)
+	})
+	//the directories changed and odd.pyc has unexpected permissions
+	sort.Strings(cm.newFiles)
+	c.Assert(cm.newFiles, DeepEquals, []string{filepath.Join(cache, "odd.pyc"), filepath.Join(root, "misplaced"), filepath.Join(root, "tool")})
+	c.Assert(cm.changedFiles, HasLen, 2)
+	c.Assert(cm.Counts()[KindExpected], Equals, 2)
+	out := buf.String()
+	c.Assert(strings.Contains(out, "New files 3\n"), Equals, true)
+	c.Assert(strings.Contains(out, "info     "+filepath.Join(cache, "mod.pyc")+" [new, allow.txt:2]\n"), Equals, true)
+	c.Assert(strings.Contains(out, "info     "+filepath.Join(root, "known")+" [new, allow.txt:1]\n"), Equals, true)
+
+	//known-bad content is never expected
+	iocs := NewHashList()
+	sum := sha256.Sum256([]byte("pyc"))
+	c.Assert(iocs.Add(sum[:], "bad cache"), IsNil)
+	cm, _ = s.compare(c, func(cm *Comparator) {
+		cm.SetAllowlist(a)
+		cm.SetHashList(iocs)
+	})
+	c.Assert(cm.iocFiles, DeepEquals, []string{filepath.Join(cache, "mod.pyc")})
+	c.Assert(cm.Counts()[KindExpected], Equals, 1)
+}
@@ -0,0 +1,140 @@
+package fcheck
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//HashBudget limits how much file content Comparator rehashes, metadata is always checked
+//without Sample the files are hashed in walk order starting after the cursor saved by the previous run,
+//so that every file is content checked once over enough runs; with Sample a random fraction of them is
+type HashBudget struct {
+	Bytes      int64         // bytes to hash at most, 0 for no limit
+	Time       time.Duration // stop hashing once the check has run this long, 0 for no limit
+	Sample     float64       // hash each file with this probability instead of rotating, 0 to rotate
+	CursorFile string        // where the rotating cursor is kept between runs, "" to always start at the beginning
+}
+
+//hashSelector picks the files Comparator rehashes within a HashBudget, it is used by the walking goroutine only
+type hashSelector struct {
+	budget     *HashBudget
+	started    time.Time
+	cursorRoot int    // index of the root the cursor is below, roots are walked in the order given
+	cursor     string // files up to this path were hashed by previous runs
+	used       int64  // bytes selected so far
+	lastRoot   int
+	last       string // last path selected
+	exhausted  bool
+	rnd        *rand.Rand
+}
+
+//newHashSelector returns a hashSelector for b, nil (select everything) if b is nil
+func newHashSelector(b *HashBudget) (*hashSelector, error) {
+	if b == nil {
+		return nil, nil
+	}
+	if b.Sample < 0 || b.Sample > 1 || b.Bytes < 0 || b.Time < 0 {
+		return nil, fmt.Errorf("invalid hash budget %+v", *b)
+	}
+	hs := &hashSelector{budget: b, started: time.Now()}
+	if b.Sample > 0 {
+		hs.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+		return hs, nil
+	}
+	if b.CursorFile != "" {
+		data, err := ioutil.ReadFile(b.CursorFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		hs.cursorRoot, hs.cursor = parseCursor(strings.TrimSuffix(string(data), "\n"))
+	}
+	return hs, nil
+}
+
+//parseCursor parses a cursor saved as <root index> <path>, a bare path (as saved by older versions) is below the first root
+func parseCursor(s string) (int, string) {
+	if i := strings.IndexByte(s, ' '); i > 0 {
+		if root, err := strconv.Atoi(s[:i]); err == nil && root >= 0 {
+			return root, s[i+1:]
+		}
+	}
+	return 0, s
+}
+
+//pastCursor returns true if path below the root with index root is walked after the cursor
+func (hs *hashSelector) pastCursor(root int, path string) bool {
+	if root != hs.cursorRoot {
+		return root > hs.cursorRoot
+	}
+	return walkBefore(hs.cursor, path)
+}
+
+//take returns true if the file at path of size, below the root with index root, should be hashed
+func (hs *hashSelector) take(root int, path string, size int64) bool {
+	if hs == nil {
+		return true
+	}
+	if hs.exhausted {
+		return false
+	}
+	if hs.rnd != nil && hs.rnd.Float64() >= hs.budget.Sample {
+		return false
+	}
+	if hs.rnd == nil && hs.cursor != "" && !hs.pastCursor(root, path) {
+		//hashed by a previous run
+		return false
+	}
+	//the first file is taken whatever its size, so that the cursor always moves on
+	if hs.budget.Bytes > 0 && hs.used > 0 && hs.used+size > hs.budget.Bytes ||
+		hs.budget.Time > 0 && time.Since(hs.started) > hs.budget.Time {
+		hs.exhausted = true
+		return false
+	}
+	hs.used += size
+	hs.lastRoot, hs.last = root, path
+	return true
+}
+
+//nextCursor returns the cursor for the next run (root index and path), complete tells if the walk reached its end
+//if it did within the budget, the next run starts again at the beginning
+func (hs *hashSelector) nextCursor(complete bool) (int, string) {
+	switch {
+	case complete && !hs.exhausted:
+		return 0, ""
+	case hs.last == "":
+		return hs.cursorRoot, hs.cursor
+	}
+	return hs.lastRoot, hs.last
+}
+
+//saveCursor stores the cursor for the next run and returns its path, complete tells if the walk reached its end
+func (hs *hashSelector) saveCursor(complete bool) (string, error) {
+	if hs == nil || hs.rnd != nil || hs.budget.CursorFile == "" {
+		return "", nil
+	}
+	root, cursor := hs.nextCursor(complete)
+	data := ""
+	if cursor != "" {
+		data = fmt.Sprintf("%d %s", root, cursor)
+	}
+	return cursor, ioutil.WriteFile(hs.budget.CursorFile, []byte(data+"\n"), 0644)
+}
+
+//ParseByteSize parses sizes such as 512, 100K, 10M, 2G or 1T (binary units)
+func ParseByteSize(s string) (int64, error) {
+	num, mult := s, int64(1)
+	if i := strings.IndexAny(s, "KMGTkmgt"); i >= 0 && i == len(s)-1 {
+		mult = 1 << (10 * uint(strings.IndexByte("KMGT", strings.ToUpper(s[i:])[0])+1))
+		num = s[:i]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,88 @@
+package fcheck
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type BudgetSuite struct{}
+
+var _ = Suite(&BudgetSuite{})
+
+func (s *BudgetSuite) TestParseByteSize(c *C) {
+	tests := []struct {
+		s string
+		n int64
+	}{
+		{"0", 0},
+		{"512", 512},
+		{"100K", 100 << 10},
+		{"10m", 10 << 20},
+		{"2G", 2 << 30},
+		{"1T", 1 << 40},
+	}
+	for _, t := range tests {
+		n, err := ParseByteSize(t.s)
+		c.Check(err, IsNil)
+		c.Check(n, Equals, t.n, Commentf("size %q", t.s))
+	}
+	for _, bad := range []string{"", "G", "-1", "1.5G", "10GB", "10X"} {
+		_, err := ParseByteSize(bad)
+		c.Check(err, NotNil, Commentf("size %q", bad))
+	}
+}
+
+func (s *BudgetSuite) TestHashSelector(c *C) {
+	hs, err := newHashSelector(&HashBudget{Bytes: 100})
+	c.Assert(err, IsNil)
+	//the first file is always taken
+	c.Assert(hs.take(0, "/a", 150), Equals, true)
+	c.Assert(hs.take(0, "/b", 1), Equals, false)
+	//nothing is taken once the budget is exhausted
+	c.Assert(hs.take(0, "/c", 0), Equals, false)
+	root, cursor := hs.nextCursor(true)
+	c.Assert(root, Equals, 0)
+	c.Assert(cursor, Equals, "/a")
+	hs, err = newHashSelector(&HashBudget{Bytes: 100})
+	c.Assert(err, IsNil)
+	hs.cursor = "/b"
+	c.Assert(hs.take(0, "/a", 10), Equals, false)
+	c.Assert(hs.take(0, "/b", 10), Equals, false)
+	c.Assert(hs.take(0, "/b/x", 10), Equals, true)
+	c.Assert(hs.take(0, "/c", 10), Equals, true)
+	//the walk ended within the budget, start over
+	_, cursor = hs.nextCursor(true)
+	c.Assert(cursor, Equals, "")
+	_, cursor = hs.nextCursor(false)
+	c.Assert(cursor, Equals, "/c")
+	var nilhs *hashSelector
+	c.Assert(nilhs.take(0, "/a", 1), Equals, true)
+	_, err = newHashSelector(&HashBudget{Sample: 2})
+	c.Assert(err, NotNil)
+}
+
+func (s *BudgetSuite) TestCursorRoots(c *C) {
+	//roots walked as given, /usr before /etc
+	cursorFile := filepath.Join(c.MkDir(), "cursor")
+	hs, err := newHashSelector(&HashBudget{Bytes: 100, CursorFile: cursorFile})
+	c.Assert(err, IsNil)
+	c.Assert(hs.take(0, "/usr/bin/ls", 60), Equals, true)
+	c.Assert(hs.take(1, "/etc/passwd", 60), Equals, false)
+	cursor, err := hs.saveCursor(false)
+	c.Assert(err, IsNil)
+	c.Assert(cursor, Equals, "/usr/bin/ls")
+	hs, err = newHashSelector(&HashBudget{Bytes: 100, CursorFile: cursorFile})
+	c.Assert(err, IsNil)
+	c.Assert(hs.take(0, "/usr/bin/cp", 10), Equals, false)
+	c.Assert(hs.take(0, "/usr/bin/mv", 10), Equals, true)
+	c.Assert(hs.take(1, "/etc/passwd", 10), Equals, true)
+
+	//a cursor saved by older versions is a bare path
+	c.Assert(ioutil.WriteFile(cursorFile, []byte("/usr/bin/ls\n"), 0644), IsNil)
+	hs, err = newHashSelector(&HashBudget{Bytes: 100, CursorFile: cursorFile})
+	c.Assert(err, IsNil)
+	c.Assert(hs.cursorRoot, Equals, 0)
+	c.Assert(hs.cursor, Equals, "/usr/bin/ls")
+}
@@ -0,0 +1,260 @@
+package fcheck
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//checkpoint kinds, a checkpoint of one walker can not be resumed by the other
+const (
+	checkpointGenerate = "generate"
+	checkpointCheck    = "check"
+)
+
+//errNotDone stops going through DB entries at the first one not walked yet
+var errNotDone = errors.New("not walked yet")
+
+//Checkpoint is the state Generator and Comparator save periodically so that an interrupted run can be resumed
+//directories are walked in lexical order, so everything walked before Next below Roots[Root]
+//and everything below the roots before it is done
+//a Comparator appends the later checkpoints of a run to the first one with only the findings and entries added since
+type Checkpoint struct {
+	Kind  string
+	DB    DBStamp // the DB the run is reading (Comparator) or writing (Generator)
+	Roots []string
+	Root  int
+	Next  string
+	//Generator: bytes of the temporary DB written before Next
+	Written int64
+	//Comparator: results so far
+	Run          RunInfo
+	ErrCount     int
+	Findings     []savedFinding   // findings reported so far
+	Pending      []savedFinding   // new files waiting to be matched against removed ones
+	Removed      []*FileCheckInfo // DB entries walked past without finding them
+	UnreadableAt []string
+}
+
+//savedFinding is a Finding as a Checkpoint keeps it, along with the state reports leave out
+type savedFinding struct {
+	Finding  *Finding
+	Revises  string
+	KnownBad bool
+}
+
+//saveFindings returns findings the way a Checkpoint keeps them
+func saveFindings(findings []*Finding) []savedFinding {
+	saved := make([]savedFinding, len(findings))
+	for i, f := range findings {
+		saved[i] = savedFinding{f, f.revises, f.knownBad}
+	}
+	return saved
+}
+
+//restoreFindings returns the findings saved by saveFindings
+func restoreFindings(saved []savedFinding) []*Finding {
+	findings := make([]*Finding, len(saved))
+	for i, sf := range saved {
+		f := sf.Finding
+		f.revises, f.knownBad = sf.Revises, sf.KnownBad
+		findings[i] = f
+	}
+	return findings
+}
+
+//DBStamp identifies a DB file, a checkpoint is only resumed against the DB it was taken with
+type DBStamp struct {
+	Size    int64
+	ModTime time.Time
+	Created time.Time // from the DB header
+}
+
+//stampDB returns the DBStamp of the DB file fname with header h
+func stampDB(fname string, h *DBHeader) (DBStamp, error) {
+	fi, err := os.Stat(fname)
+	if err != nil {
+		return DBStamp{}, err
+	}
+	st := DBStamp{Size: fi.Size(), ModTime: fi.ModTime()}
+	if h != nil {
+		st.Created = h.Created
+	}
+	return st, nil
+}
+
+//Equal returns true if both stamps are of the same DB file
+func (st DBStamp) Equal(ot DBStamp) bool {
+	return st.Size == ot.Size && st.ModTime.Equal(ot.ModTime) && st.Created.Equal(ot.Created)
+}
+
+//LoadCheckpoint reads the checkpoint saved to fname along with those appended to it
+func LoadCheckpoint(fname string) (*Checkpoint, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	cp := &Checkpoint{}
+	if err := dec.Decode(cp); err != nil {
+		return nil, fmt.Errorf("%s: %s", fname, err)
+	}
+	for {
+		next := &Checkpoint{}
+		if err := dec.Decode(next); err != nil {
+			//the end, or an append cut short, the checkpoints before it still hold
+			break
+		}
+		cp.merge(next)
+	}
+	return cp, nil
+}
+
+//merge takes over the position and results of the later checkpoint next, adding what it found to what cp has
+func (cp *Checkpoint) merge(next *Checkpoint) {
+	cp.Root, cp.Next, cp.Written = next.Root, next.Next, next.Written
+	cp.Run, cp.ErrCount = next.Run, next.ErrCount
+	cp.Findings = append(cp.Findings, next.Findings...)
+	cp.Pending = append(cp.Pending, next.Pending...)
+	cp.Removed = append(cp.Removed, next.Removed...)
+	cp.UnreadableAt = append(cp.UnreadableAt, next.UnreadableAt...)
+}
+
+//save writes the checkpoint to fname, replacing the previous one only once it is complete
+func (cp *Checkpoint) save(fname string) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cp); err != nil {
+		return err
+	}
+	return replaceFile(fname, buf.Bytes())
+}
+
+//replaceFile writes data to fname, replacing the previous file only once it is complete
+func replaceFile(fname string, data []byte) error {
+	tmp := fname + ".new"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fname)
+}
+
+//checkpointLog appends checkpoints to a file, the first one is saved in full, the others as what was added since
+//the encoder is kept between them as a gob stream sends its types only once
+type checkpointLog struct {
+	file string
+	buf  bytes.Buffer
+	enc  *gob.Encoder
+}
+
+//fresh returns true if the next checkpoint appended has to be a full one
+func (l *checkpointLog) fresh() bool {
+	return l.enc == nil
+}
+
+//append writes cp to the file, a full one replaces the previous file, after a failure the next one has to be full
+func (l *checkpointLog) append(cp *Checkpoint) (err error) {
+	full := l.fresh()
+	if full {
+		l.enc = gob.NewEncoder(&l.buf)
+	}
+	defer func() {
+		l.buf.Reset()
+		if err != nil {
+			l.enc = nil
+		}
+	}()
+	if err = l.enc.Encode(cp); err != nil {
+		return err
+	}
+	if full {
+		return replaceFile(l.file, l.buf.Bytes())
+	}
+	f, err := os.OpenFile(l.file, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(l.buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//checkpointMark is how much of what a Comparator found is saved with the checkpoints appended so far
+type checkpointMark struct {
+	findings, pending, removed, unreadable int
+}
+
+//done returns true if path below the root-th root was walked before the checkpoint
+func (cp *Checkpoint) done(root int, path string) bool {
+	if cp == nil {
+		return false
+	}
+	if root != cp.Root {
+		return root < cp.Root
+	}
+	return walkBefore(path, cp.Next)
+}
+
+//skip is the part of the filepath.WalkFunc implementations resuming from cp, it returns true for paths
+//walked before the checkpoint along with filepath.SkipDir if the whole directory was
+func (cp *Checkpoint) skip(root int, path string, info os.FileInfo) (bool, error) {
+	if !cp.done(root, path) {
+		return false, nil
+	}
+	if info != nil && info.IsDir() && !hasCleanPrefix(filepath.Clean(cp.Next), filepath.Clean(path)) {
+		return true, filepath.SkipDir
+	}
+	return true, nil
+}
+
+//checkResume returns an error unless cp is of kind and was taken walking roots
+func (cp *Checkpoint) checkResume(kind string, roots PathPrefixes) error {
+	if cp.Kind != kind {
+		return fmt.Errorf("checkpoint is of a %s run, not %s", cp.Kind, kind)
+	}
+	if len(roots) != len(cp.Roots) {
+		return fmt.Errorf("checkpoint was taken walking %v, not %v", cp.Roots, roots)
+	}
+	for i := range roots {
+		if roots[i] != cp.Roots[i] {
+			return fmt.Errorf("checkpoint was taken walking %v, not %v", cp.Roots, roots)
+		}
+	}
+	return nil
+}
+
+//checkpointTimer tells walkers when the next checkpoint is due
+//with a file but no interval a checkpoint is only saved when the walk is interrupted
+type checkpointTimer struct {
+	file  string
+	every time.Duration
+	last  time.Time
+}
+
+func (t *checkpointTimer) enabled() bool {
+	return t.file != ""
+}
+
+//due returns true once every interval
+func (t *checkpointTimer) due() bool {
+	if !t.enabled() || t.every <= 0 || time.Since(t.last) < t.every {
+		return false
+	}
+	t.last = time.Now()
+	return true
+}
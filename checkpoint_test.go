@@ -0,0 +1,59 @@
+package fcheck
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type CheckpointSuite struct{}
+
+var _ = Suite(&CheckpointSuite{})
+
+func (s *CheckpointSuite) TestFindings(c *C) {
+	state := filepath.Join(c.MkDir(), "state")
+	fc := &FileCheckInfo{Path: "/bin/x", Attrs: DefaultAttrs}
+	cp := &Checkpoint{Kind: checkpointCheck,
+		Findings: saveFindings([]*Finding{{Kind: KindChanged, Path: "/bin/x", New: fc, knownBad: true}}),
+		Pending:  saveFindings([]*Finding{{Kind: KindMoved, Path: "/bin/y", From: "/bin/z", revises: KindNew}})}
+	c.Assert(cp.save(state), IsNil)
+	cp, err := LoadCheckpoint(state)
+	c.Assert(err, IsNil)
+	//what only the comparator knows of is kept as well
+	findings := restoreFindings(append(cp.Findings, cp.Pending...))
+	c.Assert(findings, HasLen, 2)
+	c.Assert(findings[0].knownBad, Equals, true)
+	c.Assert(findings[0].New.Path, Equals, "/bin/x")
+	c.Assert(findings[1].revises, Equals, KindNew)
+	c.Assert(findings[1].From, Equals, "/bin/z")
+}
+
+func (s *CheckpointSuite) TestAppend(c *C) {
+	state := filepath.Join(c.MkDir(), "state")
+	cl := checkpointLog{file: state}
+	c.Assert(cl.append(&Checkpoint{Kind: checkpointCheck, Roots: []string{"/"}, Next: "/bin",
+		Findings: saveFindings([]*Finding{{Kind: KindNew, Path: "/a"}}), UnreadableAt: []string{"/b"}}), IsNil)
+	c.Assert(cl.fresh(), Equals, false)
+	c.Assert(cl.append(&Checkpoint{Kind: checkpointCheck, Roots: []string{"/"}, Next: "/etc", ErrCount: 1,
+		Findings: saveFindings([]*Finding{{Kind: KindChanged, Path: "/bin/x"}}),
+		Removed:  []*FileCheckInfo{{Path: "/bin/y"}}}), IsNil)
+	cp, err := LoadCheckpoint(state)
+	c.Assert(err, IsNil)
+	c.Assert(cp.Next, Equals, "/etc")
+	c.Assert(cp.ErrCount, Equals, 1)
+	c.Assert(cp.Roots, DeepEquals, []string{"/"})
+	c.Assert(cp.Findings, HasLen, 2)
+	c.Assert(cp.Findings[1].Finding.Path, Equals, "/bin/x")
+	c.Assert(cp.Removed, HasLen, 1)
+	c.Assert(cp.UnreadableAt, DeepEquals, []string{"/b"})
+	//an append cut short leaves the checkpoints before it
+	fi, err := os.Stat(state)
+	c.Assert(err, IsNil)
+	c.Assert(cl.append(&Checkpoint{Kind: checkpointCheck, Next: "/usr"}), IsNil)
+	c.Assert(os.Truncate(state, fi.Size()+3), IsNil)
+	cp, err = LoadCheckpoint(state)
+	c.Assert(err, IsNil)
+	c.Assert(cp.Next, Equals, "/etc")
+	c.Assert(cp.Findings, HasLen, 2)
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"flag"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/jlabath/fcheck"
 )
@@ -17,57 +20,490 @@ const (
 	version = "0.3 (Dec 2015)"
 )
 
+//exit codes, the change and walk error bits can be combined
+//all of them stay below 128, which shells use for processes killed by a signal
+const (
+	exitClean      = 0
+	exitNew        = 1 << 0      // new files found
+	exitRemoved    = 1 << 1      // removed files found
+	exitChanged    = 1 << 2      // changed files found
+	exitWalkError  = 1 << 3      // parts of the filesystem could not be walked
+	exitDBError    = 1 << 4      // DB could not be read or written
+	exitAlert      = 1 << 5      // files on a known-bad hash list or mass changes found
+	exitIncomplete = 1 << 6      // interrupted by a signal, the report is partial
+	exitUsage      = exitDBError // invalid command line, nothing was checked
+)
+
 func main() {
 	var (
 		generateDB = flag.Bool("gendb", false, "generates the db")
-		pathPtr    = flag.String("path", "/", "path to check/generate db for")
+		paths      pathList
+		accept     pathList
+		rewrites   pathList
+		iocLists   pathList
+		lookups    pathList
+		allowLists pathList
 		showPtr    = flag.Bool("show", false, "show entries that start with provided path")
+		exportPtr  = flag.String("export", "", "write the entries that start with provided path as sha512sum, mtree, csv or jsonl")
 		cpuPtr     = flag.String("num", "runtime.NumCPU()", "How many goroutines to run when computing checksums")
-		excludePtr = flag.String("exclude_from", "excludes.txt", "File which contains path prefixes to ignore")
+		excludePtr = flag.String("exclude_from", "excludes.txt", "File which contains exclude rules (paths, globs, re: regular expressions, ! re-includes)")
 		verbosePtr = flag.Bool("v", false, "verbose mode")
+		formatPtr  = flag.String("format", "text", "report format when checking: text, json or ndjson")
+		movesPtr   = flag.Bool("moves", true, "hash new files when checking to detect moved and copied files")
+		policyPtr  = flag.String("policy", "", "File which assigns attribute groups to paths (what to record and check)")
+		xdevPtr    = flag.Bool("xdev", false, "do not descend into other filesystems (mount points of other devices) below the paths")
+		progPtr    = flag.String("progress", "", "print progress to stderr at intervals: text or json (one object per line)")
+		progIntPtr = flag.Duration("progress-interval", 10*time.Second, "how often to print progress")
+		minSevPtr  = flag.String("min-severity", "info", "leave out findings below this severity: info, low, medium, high or critical")
+		cpIntPtr   = flag.Duration("checkpoint", 0, "save a checkpoint to -state at this interval when generating or checking, and when interrupted")
+		statePtr   = flag.String("state", dbfile+".state", "file checkpoints are saved to")
+		hashBPtr   = flag.String("hash-bytes", "", "when checking, rehash at most this much content (e.g. 50G), continuing where the previous run stopped")
+		hashTPtr   = flag.Duration("hash-time", 0, "when checking, stop rehashing content after this long, continuing where the previous run stopped")
+		samplePtr  = flag.Float64("hash-sample", 0, "when checking, rehash a random fraction (0-1] of the files instead of rotating through them")
+		cursorPtr  = flag.String("hash-cursor", dbfile+".cursor", "file keeping where the next -hash-bytes/-hash-time run continues rehashing")
+		updatePtr  = flag.Bool("update", false, "check and then write the changes found into the db, so they become the new baseline")
+		kindsPtr   = flag.String("accept-kinds", "", "with -update, only accept these kinds of changes: comma separated new, changed, removed, moved, copied")
+		reviewPtr  = flag.Bool("review", false, "with -update, ask whether to accept each change")
+		keepPtr    = flag.Int("generations", 0, "with -gendb or -update, keep this many generations of the db (in fcheck.db.history) instead of just the current one")
+		labelPtr   = flag.String("label", "", "with -gendb or -update, label the new generation")
+		genPtr     = flag.String("generation", "", "check against or show this generation instead of the current db: 1 for the previous one and so on, or a label")
+		historyPtr = flag.String("history", "", "show how the record of this path evolved across the generations of the db")
+		diffPtr    = flag.String("diff", "", "compare this older db with the db (or -generation) instead of checking the filesystem")
+		importPtr  = flag.String("import", "", "write the db from the baselines of other tools given as arguments (- for stdin): sums (sha*sum, md5sum, dpkg md5sums), mtree or rpm (rpm -qa --dump)")
+		impRootPtr = flag.String("import-root", "/", "with -import, what relative paths in the baselines are relative to")
+		mergePtr   = flag.Bool("merge", false, "with -import, add to the db instead of replacing it")
+		heurPtr    = flag.Bool("heuristics", true, "when checking, tag new and changed files that look like an intrusion (setuid, world-writable, timestomped, executable in /tmp, hidden, control characters)")
+		stompPtr   = flag.Duration("timestomp-margin", fcheck.DefaultTimestompMargin, "with -heuristics, how much older than its ctime a file's mtime has to be to look forged, 0 only checks changed files for an mtime set back")
+		massPtr    = flag.Float64("mass-change", 0, "when checking, alert for directories where at least this fraction (0-1] of the files changed, were added or removed")
+		massMinPtr = flag.Int("mass-change-min", 100, "with -mass-change, leave out directories with fewer files")
+		entropyPtr = flag.Int("entropy-alert", 10, "when checking files recorded with the entropy attribute, alert when this many of them turned random looking, 0 for never, on by default only if the policy records entropy")
+		dpkgPtr    = flag.String("dpkg", "", "when checking, annotate findings with the owning package from this dpkg admin directory (e.g. /var/lib/dpkg)")
+		resumePtr  = flag.Bool("resume", false, "continue the interrupted run from the checkpoint in -state, refused if the db changed since")
 		walker     fcheck.Walker
 	)
 
-	flag.Parse()
+	flag.Var(&paths, "path", "path to check/generate db for, repeat for several paths (default / or the paths the db was generated for)")
+	flag.Var(&accept, "accept", "with -update, only accept changes at or below this path, repeat for several paths")
+	flag.Var(&iocLists, "ioc", "when generating or checking, flag files whose checksum is in this known-bad hash list (hex or CSV), repeat for several lists")
+	flag.Var(&allowLists, "allow", "when checking, report new and changed files matching this allowlist of expected paths and their attributes as expected, repeat for several lists")
+	flag.Var(&lookups, "lookup-hash", "show the entries of the db with this checksum, repeat for several checksums")
+	flag.Var(&rewrites, "rewrite", "with -diff, compare paths of the older db below FROM as if they were below TO (FROM=TO), repeat for several prefixes")
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(exitClean)
+		}
+		os.Exit(exitUsage)
+	}
 
 	askedCPU, err := strconv.Atoi(*cpuPtr)
 	if err != nil || askedCPU < 1 {
 		askedCPU = runtime.NumCPU()
 	}
 
+	if *massPtr < 0 || *massPtr > 1 {
+		log.Printf("Invalid -mass-change=%v, expected a fraction of the files (0-1]", *massPtr)
+		os.Exit(exitUsage)
+	}
+
+	if *progPtr != "" && *progPtr != fcheck.FormatText && *progPtr != fcheck.FormatJSON || *progIntPtr <= 0 {
+		log.Printf("Invalid -progress=%s -progress-interval=%s", *progPtr, *progIntPtr)
+		os.Exit(exitUsage)
+	}
+
 	log.Printf("fcheck %s\n", version)
+	history := fcheck.NewHistory(dbfile, *keepPtr)
+	if *historyPtr != "" {
+		versions, err := history.FileHistory(*historyPtr)
+		if err == nil {
+			err = fcheck.WriteFileHistory(os.Stdout, versions)
+		}
+		if err != nil {
+			log.Printf("Unable to show history: %s", err.Error())
+			os.Exit(exitDBError)
+		}
+		os.Exit(exitClean)
+	}
+	if *importPtr != "" {
+		im := fcheck.NewImporter(dbfile)
+		im.SetRoot(*impRootPtr)
+		im.SetMerge(*mergePtr)
+		im.SetLabel(*labelPtr)
+		if *keepPtr > 0 {
+			im.SetHistory(history)
+		}
+		os.Exit(importBaselines(im, *importPtr, flag.Args()))
+	}
+	db := dbfile
+	if *genPtr != "" {
+		if *generateDB || *updatePtr {
+			log.Printf("-generation can only be used to check, diff, show or export")
+			os.Exit(exitUsage)
+		}
+		gen, err := history.Resolve(*genPtr)
+		if err != nil {
+			log.Printf("Invalid -generation: %s", err.Error())
+			os.Exit(exitUsage)
+		}
+		db = gen.File
+	}
+	if *diffPtr != "" && (*generateDB || *updatePtr || *showPtr || *exportPtr != "" || len(lookups) > 0 || *resumePtr) {
+		log.Printf("-diff can only be used with the check options")
+		os.Exit(exitUsage)
+	}
+	progress := fcheck.NewProgress()
+	var updater *fcheck.Updater
+	var checkpoint *fcheck.Checkpoint
+	if *resumePtr {
+		if *showPtr || *exportPtr != "" || len(lookups) > 0 {
+			log.Printf("-resume can not be used with -show, -export or -lookup-hash")
+			os.Exit(exitUsage)
+		}
+		if checkpoint, err = fcheck.LoadCheckpoint(*statePtr); err != nil {
+			log.Printf("Unable to read checkpoint: %s", err.Error())
+			os.Exit(exitUsage)
+		}
+		if len(paths) == 0 {
+			paths = checkpoint.Roots
+		}
+	}
+	stateFile := ""
+	if *cpIntPtr > 0 || *resumePtr {
+		stateFile = *statePtr
+	}
+	var policy *fcheck.Policy
+	if *policyPtr != "" {
+		if policy, err = fcheck.LoadPolicy(*policyPtr); err != nil {
+			log.Printf("Unable to read policy: %s", err.Error())
+			os.Exit(exitUsage)
+		}
+	}
+	var iocs *fcheck.HashList
+	if len(iocLists) > 0 {
+		iocs = fcheck.NewHashList()
+		for _, f := range iocLists {
+			n, err := iocs.Load(f)
+			if err != nil {
+				log.Printf("Unable to read hash list: %s", err.Error())
+				os.Exit(exitUsage)
+			}
+			log.Printf("Read %d checksums from %s", n, f)
+		}
+	}
+	var allow *fcheck.Allowlist
+	if len(allowLists) > 0 {
+		allow = fcheck.NewAllowlist()
+		for _, f := range allowLists {
+			n, err := allow.Load(f)
+			if err != nil {
+				log.Printf("Unable to read allowlist: %s", err.Error())
+				os.Exit(exitUsage)
+			}
+			log.Printf("Read %d entries from %s", n, f)
+		}
+	}
+	minSeverity, err := fcheck.ParseSeverity(*minSevPtr)
+	if err != nil {
+		log.Printf("Invalid -min-severity: %s", err.Error())
+		os.Exit(exitUsage)
+	}
 	switch {
 	case *showPtr:
-		walker = fcheck.NewPrinter(dbfile)
+		walker = fcheck.NewPrinter(db)
+	case len(lookups) > 0:
+		if walker, err = fcheck.NewHashLookup(db, lookups...); err != nil {
+			log.Printf("Invalid -lookup-hash: %s", err.Error())
+			os.Exit(exitUsage)
+		}
+	case *exportPtr != "":
+		if walker, err = fcheck.NewExporter(db, *exportPtr); err != nil {
+			log.Printf("Invalid -export: %s", err.Error())
+			os.Exit(exitUsage)
+		}
 	case *generateDB:
-		walker = fcheck.NewGenerator(dbfile, askedCPU, *verbosePtr)
+		g := fcheck.NewGenerator(dbfile, askedCPU, *verbosePtr)
+		g.SetPolicy(policy)
+		g.SetProgress(progress)
+		g.SetLabel(*labelPtr)
+		g.SetHashList(iocs)
+		if *keepPtr > 0 {
+			g.SetHistory(history)
+		}
+		g.SetCheckpoint(stateFile, *cpIntPtr)
+		if checkpoint != nil {
+			g.Resume(checkpoint)
+		}
+		walker = g
+	case *diffPtr != "":
+		d := fcheck.NewDBDiff(*diffPtr, db)
+		var rws []fcheck.PathRewrite
+		for _, v := range rewrites {
+			rw, err := fcheck.ParsePathRewrite(v)
+			if err != nil {
+				log.Printf("Invalid -rewrite: %s", err.Error())
+				os.Exit(exitUsage)
+			}
+			rws = append(rws, rw)
+		}
+		d.SetRewrites(rws...)
+		d.SetReporter(newReporter(*formatPtr))
+		d.SetPolicy(policy)
+		d.SetMinSeverity(minSeverity)
+		walker = d
 	default:
-		walker = fcheck.NewComparator(dbfile, askedCPU, *verbosePtr)
+		rep := newReporter(*formatPtr)
+		cm := fcheck.NewComparator(db, askedCPU, *verbosePtr)
+		if *updatePtr {
+			upd := fcheck.NewUpdater(dbfile, rep)
+			upd.SetPolicy(policy)
+			upd.SetPaths(accept...)
+			upd.SetLabel(*labelPtr)
+			if *keepPtr > 0 {
+				upd.SetHistory(history)
+			}
+			if *kindsPtr != "" {
+				if err := upd.SetKinds(strings.Split(*kindsPtr, ",")...); err != nil {
+					log.Printf("Invalid -accept-kinds: %s", err.Error())
+					os.Exit(exitUsage)
+				}
+			}
+			if *reviewPtr {
+				upd.SetReviewer(fcheck.NewPromptReviewer(os.Stdin, os.Stderr))
+			}
+			updater, rep = upd, upd
+		}
+		cm.SetReporter(rep)
+		cm.SetHashList(iocs)
+		cm.SetAllowlist(allow)
+		if *dpkgPtr != "" {
+			d, err := fcheck.LoadDpkg(*dpkgPtr)
+			if err != nil {
+				log.Printf("Unable to read dpkg metadata: %s", err.Error())
+				os.Exit(exitUsage)
+			}
+			cm.AddAnnotator(d)
+		}
+		//the entropy alert is on by default only where the policy records entropy
+		entropyFiles := *entropyPtr
+		if !flagGiven("entropy-alert") && !policy.Records(fcheck.AttrEntropy) {
+			entropyFiles = 0
+		}
+		if *massPtr > 0 || entropyFiles > 0 {
+			cm.SetMassChange(fcheck.NewMassChange(*massPtr, *massMinPtr, entropyFiles))
+		}
+		if *heurPtr {
+			h := fcheck.NewHeuristics()
+			h.SetTimestompMargin(*stompPtr)
+			cm.AddAnnotator(h)
+		}
+		cm.SetDetectMoves(*movesPtr)
+		cm.SetPolicy(policy)
+		cm.SetMinSeverity(minSeverity)
+		cm.SetProgress(progress)
+		cm.SetCheckpoint(stateFile, *cpIntPtr)
+		if *hashBPtr != "" || *hashTPtr > 0 || *samplePtr > 0 {
+			budget := &fcheck.HashBudget{Time: *hashTPtr, Sample: *samplePtr, CursorFile: *cursorPtr}
+			if *hashBPtr != "" {
+				if budget.Bytes, err = fcheck.ParseByteSize(*hashBPtr); err != nil {
+					log.Printf("Invalid -hash-bytes: %s", err.Error())
+					os.Exit(exitUsage)
+				}
+			}
+			if *samplePtr < 0 || *samplePtr > 1 {
+				log.Printf("Invalid -hash-sample=%g", *samplePtr)
+				os.Exit(exitUsage)
+			}
+			cm.SetHashBudget(budget)
+		}
+		if checkpoint != nil {
+			cm.Resume(checkpoint)
+		}
+		walker = cm
+	}
+	excludes, err := makeExcludeList(*excludePtr)
+	if err != nil {
+		log.Printf("Unable to read exclude rules: %s", err.Error())
+		os.Exit(exitUsage)
 	}
+	excludes.SetOneFilesystem(*xdevPtr)
 	if err := walker.Start(); err != nil {
-		log.Fatalf("Unable to start fs walker due to %s", err.Error())
+		log.Printf("Unable to start fs walker due to %s", err.Error())
+		os.Exit(exitDBError)
+	}
+	if len(paths) == 0 {
+		paths = defaultPaths(walker)
 	}
-	defer func() {
-		log.Println("finished")
+	//the first SIGINT or SIGTERM stops walking and still reports on what was walked, a second one kills
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
 	}()
-	defer walker.Stop()
-	walker.StartWalking(*pathPtr, makeExcludeList(*excludePtr))
+	progCtx, progStop := context.WithCancel(context.Background())
+	if *progPtr != "" {
+		go progress.Run(progCtx, os.Stderr, *progPtr, *progIntPtr)
+	}
+	dumpProgressOnSignal(progCtx, progress, *progPtr)
+	code := exitClean
+	if err := walker.StartWalking(ctx, paths, excludes); err != nil {
+		log.Printf("Trouble walking %s: %s", strings.Join(paths, ", "), err.Error())
+		code |= exitCode(err, exitWalkError)
+		if err == context.Canceled && stateFile != "" {
+			log.Printf("Checkpoint saved to %s, continue with -resume", stateFile)
+		}
+	}
+	if err := walker.Stop(); err != nil {
+		log.Printf("Trouble finishing up: %s", err.Error())
+		code |= exitCode(err, exitWalkError)
+	}
+	if updater != nil && code&(exitIncomplete|exitDBError) == 0 {
+		accepted, rejected, err := updater.Commit()
+		if err != nil {
+			log.Printf("Unable to update the db: %s", err.Error())
+			code |= exitCode(err, exitDBError)
+		} else {
+			log.Printf("Updated the db with %d changes, %d not accepted", accepted, rejected)
+		}
+	}
+	if ec, ok := walker.(fcheck.ErrorCounter); ok && ec.WalkErrors() > 0 {
+		code |= exitWalkError
+	}
+	if cm, ok := walker.(interface{ Counts() map[string]int }); ok {
+		counts := cm.Counts()
+		//a move is a removal plus a new file, a copy is a new file
+		if counts[fcheck.KindNew]+counts[fcheck.KindMoved]+counts[fcheck.KindCopied] > 0 {
+			code |= exitNew
+		}
+		if counts[fcheck.KindRemoved]+counts[fcheck.KindMoved] > 0 {
+			code |= exitRemoved
+		}
+		if counts[fcheck.KindChanged] > 0 {
+			code |= exitChanged
+		}
+		if counts[fcheck.KindUnreadable] > 0 {
+			code |= exitWalkError
+		}
+		if counts[fcheck.KindIOC]+counts[fcheck.KindMassChange] > 0 {
+			code |= exitAlert
+		}
+	}
+	if g, ok := walker.(*fcheck.Generator); ok && len(g.IOCMatches()) > 0 {
+		log.Printf("Found %d known bad files", len(g.IOCMatches()))
+		code |= exitAlert
+	}
+	progStop()
+	if *progPtr != "" {
+		progress.Write(os.Stderr, *progPtr)
+	}
+	log.Println("finished")
+	os.Exit(code)
 }
 
-func makeExcludeList(path string) (e fcheck.StringSet) {
-	e = make(fcheck.StringSet)
-	if path == "" {
+//dumpProgressOnSignal prints the progress to stderr whenever one of dumpSignals arrives until ctx is done
+func dumpProgressOnSignal(ctx context.Context, progress *fcheck.Progress, format string) {
+	if len(dumpSignals) == 0 {
 		return
 	}
-	f, err := os.Open(path)
+	if format == "" {
+		format = fcheck.FormatText
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, dumpSignals...)
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ch:
+				progress.Write(os.Stderr, format)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+//pathList collects the values of a repeated flag
+type pathList []string
+
+func (p *pathList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *pathList) Set(v string) error {
+	*p = append(*p, v)
+	return nil
+}
+
+//importBaselines reads the baselines in files (stdin if none) of format into the db, it returns the exit code
+func importBaselines(im *fcheck.Importer, format string, files []string) int {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, f := range files {
+		n, err := im.ReadFile(format, f)
+		if err != nil {
+			log.Printf("Unable to import %s: %s", f, err.Error())
+			return exitUsage
+		}
+		log.Printf("Read %d records from %s", n, f)
+	}
+	if err := im.Commit(); err != nil {
+		log.Printf("Unable to write the db: %s", err.Error())
+		return exitDBError
+	}
+	return exitClean
+}
+
+//newReporter returns the Reporter for format writing to stdout, it exits on an unknown format
+func newReporter(format string) fcheck.Reporter {
+	rep, err := fcheck.NewReporter(format, os.Stdout)
 	if err != nil {
-		log.Println(err)
-		return
+		log.Printf("Unable to create report: %s", err.Error())
+		os.Exit(exitUsage)
+	}
+	return rep
+}
+
+//defaultPaths returns the paths the db was generated for when checking or showing, / otherwise
+func defaultPaths(walker fcheck.Walker) []string {
+	if r, ok := walker.(interface{ Header() *fcheck.DBHeader }); ok {
+		if h := r.Header(); h != nil && len(h.Roots) > 0 {
+			return h.Roots
+		}
 	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		e.Add(strings.TrimSpace(scanner.Text()))
+	return []string{"/"}
+}
+
+//flagGiven returns true if the flag name was set on the command line, rather than left at its default
+func flagGiven(name string) bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
+
+//exitCode returns exitDBError for DB errors, exitIncomplete when interrupted and def otherwise
+func exitCode(err error, def int) int {
+	if _, ok := err.(*fcheck.DBError); ok {
+		return exitDBError
+	}
+	if err == context.Canceled {
+		return exitIncomplete
+	}
+	return def
+}
+
+func makeExcludeList(path string) (*fcheck.Matcher, error) {
+	if path == "" {
+		return fcheck.NewMatcher(), nil
+	}
+	m, err := fcheck.LoadMatcher(path)
+	if os.IsNotExist(err) {
+		log.Println(err)
+		return fcheck.NewMatcher(), nil
 	}
-	return
+	return m, err
 }
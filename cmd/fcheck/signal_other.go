@@ -0,0 +1,9 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import "os"
+
+//dumpSignals is empty where there is no SIGUSR1
+var dumpSignals []os.Signal
@@ -0,0 +1,12 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+//dumpSignals make fcheck print its progress (kill -USR1)
+var dumpSignals = []os.Signal{syscall.SIGUSR1}
@@ -1,12 +1,15 @@
 package fcheck
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
+	"sync"
+	"time"
 )
 
 //Comparator represents a file system walker that checks previously generated db and compares it to a filesystem
@@ -15,16 +18,52 @@ type Comparator struct {
 	newFiles     []string
 	changedFiles []string
 	removedFiles []string
-	pathWalked   string
-	taskCh       chan *FileCheckInfo
+	movedFiles   []string
+	copiedFiles  []string
+	unreadable   []string
+	iocFiles     []string
+	expected     []string
+	massChanges  []string
+	seen         StringSet  // paths walked, DB entries not in here were removed
+	unreadableAt StringSet  // paths that could not be read, DB entries below them are not checked
+	unreadables  []string   // the paths in unreadableAt in the order they were added
+	pendingNew   []*Finding // new files reported provisionally until they can be matched against removed ones
+	detectMoves  bool
+	policy       *Policy
+	annotators   []Annotator
+	iocs         *HashList
+	allow        *Allowlist
+	mass         *MassChange
+	progress     *Progress
+	minSeverity  Severity
+	roots        PathPrefixes // paths walked, removals are only looked for below them
+	stopRoot     int          // index of the root being walked when interrupted
+	curRoot      int          // index of the root being walked
+	ctx          context.Context
+	timer        checkpointTimer
+	cpLog        checkpointLog
+	cpSaved      checkpointMark   // what the checkpoints appended so far hold
+	cpDone       *Checkpoint      // how far the DB entries were gone through for cpRemoved
+	cpRemoved    []*FileCheckInfo // DB entries walked past without finding them
+	resume       *Checkpoint
+	resumeGone   StringSet      // the paths of resume.Removed
+	recorded     []*Finding     // findings passed to the reporter, saved with checkpoints
+	inflight     sync.WaitGroup // entries passed to the compare workers and not compared yet
+	budget       *HashBudget
+	selector     *hashSelector
+	taskCh       chan compareTask
 	quitCh       chan bool
 	doneCh       chan bool
-	changedCh    chan string
-	newCh        chan string
+	findingCh    chan *Finding
 	numWorkers   int
 	console      io.Writer
-	excludes     []string
+	excludes     *walkScope
 	verbose      bool
+	reporter     Reporter
+	run          RunInfo
+	errCount     int
+	dbErr        error // first DB error seen by the compare workers
+	l            sync.Mutex
 }
 
 //NewComparator returns new Comparator instance backed by the DB in dbfname
@@ -33,25 +72,100 @@ func NewComparator(dbfname string, num int, verbose bool) *Comparator {
 		FileInfoReader: NewDBReader(dbfname),
 		numWorkers:     num,
 		console:        os.Stdout,
-		verbose:        verbose}
+		verbose:        verbose,
+		detectMoves:    true,
+		run:            RunInfo{DB: dbfname}}
+}
+
+//SetPolicy sets the Policy deciding which attributes are checked for each path, by default DefaultAttrs are
+//attributes that were not recorded when the DB was generated are never checked
+func (rcv *Comparator) SetPolicy(p *Policy) {
+	rcv.policy = p
+}
+
+//SetHashList sets the known-bad checksums files are matched against, whether they changed or not
+//files are read for the checksums the DB does not have, except those the HashBudget leaves out
+func (rcv *Comparator) SetHashList(h *HashList) {
+	rcv.iocs = h
+}
+
+//SetAllowlist sets the known-good content and paths, new and changed files matching them are reported as expected
+//with SeverityInfo, they are listed apart and not counted as new or changed
+func (rcv *Comparator) SetAllowlist(a *Allowlist) {
+	rcv.allow = a
+}
+
+//SetMassChange sets the MassChange counting the findings, its alerts are reported once the DB has been gone through
+func (rcv *Comparator) SetMassChange(m *MassChange) {
+	rcv.mass = m
+}
+
+//AddAnnotator adds an Annotator called on every finding, in the order they were added, before its severity is assigned
+func (rcv *Comparator) AddAnnotator(a Annotator) {
+	rcv.annotators = append(rcv.annotators, a)
+}
+
+//SetMinSeverity sets the Severity below which findings are left out of the report and the counts
+func (rcv *Comparator) SetMinSeverity(s Severity) {
+	rcv.minSeverity = s
+}
+
+//SetProgress sets the Progress updated while comparing, the total is the number of DB entries below the roots
+func (rcv *Comparator) SetProgress(p *Progress) {
+	rcv.progress = p
+}
+
+//SetCheckpoint makes the Comparator save a Checkpoint to file every interval (unless 0) and when interrupted
+func (rcv *Comparator) SetCheckpoint(file string, every time.Duration) {
+	rcv.timer = checkpointTimer{file: file, every: every, last: time.Now()}
+	rcv.cpLog = checkpointLog{file: file}
+}
+
+//Resume makes the Comparator continue the run cp was taken of, the report includes the findings made before
+//StartWalking fails unless the DB and the roots are the same as then
+func (rcv *Comparator) Resume(cp *Checkpoint) {
+	rcv.resume = cp
+}
+
+//SetHashBudget limits the files whose content is rehashed, by default all of them are
+func (rcv *Comparator) SetHashBudget(b *HashBudget) {
+	rcv.budget = b
+}
+
+//SetDetectMoves enables or disables hashing of new files in order to report moved and copied files
+func (rcv *Comparator) SetDetectMoves(detect bool) {
+	rcv.detectMoves = detect
+}
+
+//SetReporter sets the Reporter used to render findings, by default a text report is printed to console
+func (rcv *Comparator) SetReporter(rep Reporter) {
+	rcv.reporter = rep
 }
 
 //Start initializes generator before walking (e.g. start workers, open DB)
 func (rcv *Comparator) Start() error {
-	rcv.taskCh = make(chan *FileCheckInfo)
+	rcv.taskCh = make(chan compareTask)
 	rcv.quitCh = make(chan bool)
 	rcv.doneCh = make(chan bool)
-	rcv.newCh = make(chan string)
-	rcv.changedCh = make(chan string)
+	rcv.findingCh = make(chan *Finding)
+	if rcv.reporter == nil {
+		rcv.reporter = &TextReporter{out: rcv.console}
+	}
 	//start the append routine
 	go func() {
 	FLOOP:
 		for {
 			select {
-			case x := <-rcv.changedCh:
-				rcv.changedFiles = append(rcv.changedFiles, x)
-			case x := <-rcv.newCh:
-				rcv.newFiles = append(rcv.newFiles, x)
+			case x := <-rcv.findingCh:
+				if x == nil {
+					//sent by checkpoint to make sure all findings before it are recorded
+					continue
+				}
+				if x.Kind == KindNew && len(x.New.Digest) > 0 {
+					rcv.addPending(x)
+				} else {
+					rcv.addFinding(x)
+				}
 			case <-rcv.doneCh:
 				break FLOOP
 			}
@@ -64,9 +178,11 @@ func (rcv *Comparator) Start() error {
 		go func(n int) {
 			for {
 				select {
-				case fc := <-rcv.taskCh:
-					//log.Printf("worker %d saving %s\n", n, fc.Path)
-					rcv.compareFc(fc)
+				case t := <-rcv.taskCh:
+					//log.Printf("worker %d saving %s\n", n, t.fc.Path)
+					rcv.compareFc(t.fc, t.hash)
+					rcv.progress.entryDone()
+					rcv.inflight.Done()
 				case <-rcv.quitCh:
 					//log.Printf("worker %d quitting\n", n)
 					return
@@ -81,68 +197,623 @@ func (rcv *Comparator) Start() error {
 }
 
 //StartWalking will start the actual filesystem walking and comparison with DB
-func (rcv *Comparator) StartWalking(path string, exclude StringSet) error {
-	rcv.pathWalked = path
-	rcv.excludes = exclude.Items()
-	return filepath.Walk(path, rcv.Walk)
+//if ctx is cancelled the walk stops and Stop reports only on what was walked so far
+func (rcv *Comparator) StartWalking(ctx context.Context, roots []string, exclude *Matcher) error {
+	rcv.ctx = ctx
+	rcv.excludes = exclude.newScope(roots)
+	rcv.roots = rcv.excludes.roots
+	if len(rcv.roots) == 0 {
+		return errNoRoots
+	}
+	if h := rcv.Header(); h != nil {
+		generated := NewPathPrefixes(h.Roots...)
+		for _, root := range rcv.roots {
+			if !generated.Match(root) {
+				log.Printf("%s is not covered by the db (generated for %v), everything in it will be new\n", root, h.Roots)
+			}
+		}
+	}
+	if rcv.progress != nil && rcv.progress.Total() == 0 {
+		var total int64
+		for _, root := range rcv.roots {
+			total += rcv.Count(root)
+		}
+		rcv.progress.SetTotal(total)
+	}
+	rcv.seen = make(StringSet)
+	rcv.unreadableAt = make(StringSet)
+	rcv.run.Roots = rcv.roots
+	rcv.run.MinSeverity = rcv.minSeverity
+	rcv.run.Host, _ = os.Hostname()
+	rcv.run.Started = time.Now()
+	rcv.run.Sampled = rcv.budget != nil
+	var err error
+	if rcv.selector, err = newHashSelector(rcv.budget); err != nil {
+		return err
+	}
+	if rcv.resume != nil {
+		if err := rcv.restore(); err != nil {
+			return err
+		}
+	}
+	if err := rcv.reporter.Begin(&rcv.run); err != nil {
+		log.Printf("Trouble writing report: %s\n", err)
+	}
+	if rcv.resume != nil {
+		for _, f := range restoreFindings(append(rcv.resume.Findings, rcv.resume.Pending...)) {
+			rcv.findingCh <- f
+		}
+	}
+	for i, root := range rcv.roots {
+		rcv.curRoot = i
+		if err := filepath.Walk(root, rcv.Walk); err != nil {
+			if ctx.Err() != nil {
+				if rcv.timer.enabled() {
+					rcv.checkpoint(rcv.run.InterruptedAt)
+				}
+				rcv.interrupted(i)
+			}
+			return err
+		}
+	}
+	return nil
 }
 
-//Walk is the implemention of filepath.WalkFunc meant to be passed to filepath.Walk
-func (rcv *Comparator) Walk(path string, info os.FileInfo, err error) error {
-	for _, v := range rcv.excludes {
-		if strings.HasPrefix(path, v) {
-			//path is excluded
-			if info.IsDir() {
+//restore takes over the state of the run resumed, refusing if the DB changed since
+func (rcv *Comparator) restore() error {
+	cp := rcv.resume
+	if err := cp.checkResume(checkpointCheck, rcv.roots); err != nil {
+		return err
+	}
+	st, err := rcv.stamp()
+	if err != nil {
+		return err
+	}
+	if !st.Equal(cp.DB) {
+		return fmt.Errorf("%s changed since the checkpoint was taken", rcv.run.DB)
+	}
+	rcv.resumeGone = make(StringSet)
+	for _, fc := range cp.Removed {
+		rcv.resumeGone.Add(fc.Path)
+	}
+	rcv.cpRemoved = append(rcv.cpRemoved, cp.Removed...)
+	rcv.cpDone = &Checkpoint{Root: cp.Root, Next: cp.Next}
+	for _, p := range cp.UnreadableAt {
+		rcv.addUnreadable(p)
+	}
+	rcv.errCount = cp.ErrCount
+	rcv.run.Started = cp.Run.Started
+	rcv.run.Checked = cp.Run.Checked
+	//the suppressed findings are counted again as the recorded ones are replayed
+	rcv.run.ContentChecked = cp.Run.ContentChecked
+	rcv.run.ContentSkipped = cp.Run.ContentSkipped
+	rcv.run.Resumed = true
+	return nil
+}
+
+//stamp returns the DBStamp of the DB compared with
+func (rcv *Comparator) stamp() (DBStamp, error) {
+	return stampDB(rcv.run.DB, rcv.Header())
+}
+
+//checkpoint waits for the entries walked so far to be compared and saves a Checkpoint with next as the first path not done
+//only what was found since the previous checkpoint is appended, unless there is none to append to
+func (rcv *Comparator) checkpoint(next string) {
+	rcv.inflight.Wait()
+	//the appender is done with everything found so far once it receives this
+	rcv.findingCh <- nil
+	st, err := rcv.stamp()
+	if err != nil {
+		log.Printf("Trouble saving checkpoint: %s\n", err)
+		return
+	}
+	cp := &Checkpoint{Kind: checkpointCheck, DB: st, Roots: rcv.roots, Root: rcv.curRoot, Next: next, Run: rcv.run,
+		ErrCount: rcv.errCount}
+	//the DB entries walked past are all there is to remember of what was seen
+	if err = rcv.scanRemoved(cp.Root, cp.Next); err != nil {
+		log.Printf("Trouble saving checkpoint: %s\n", err)
+		return
+	}
+	from := rcv.cpSaved
+	if rcv.cpLog.fresh() {
+		from = checkpointMark{}
+	}
+	cp.Findings = saveFindings(rcv.recorded[from.findings:])
+	cp.Pending = saveFindings(rcv.pendingNew[from.pending:])
+	cp.Removed = rcv.cpRemoved[from.removed:]
+	cp.UnreadableAt = rcv.unreadables[from.unreadable:]
+	if err = rcv.cpLog.append(cp); err != nil {
+		log.Printf("Trouble saving checkpoint: %s\n", err)
+		return
+	}
+	rcv.cpSaved = checkpointMark{len(rcv.recorded), len(rcv.pendingNew), len(rcv.cpRemoved), len(rcv.unreadables)}
+}
+
+//scanRemoved adds the DB entries walked past without finding them to cpRemoved, going through those walked
+//since it was last called up to next below the root-th root only
+func (rcv *Comparator) scanRemoved(root int, next string) error {
+	prev, now := rcv.cpDone, &Checkpoint{Root: root, Next: next}
+	first, n := 0, len(rcv.cpRemoved)
+	if prev != nil {
+		first = prev.Root
+	}
+	for i := first; i <= root; i++ {
+		err := rcv.Paths(rcv.roots[i], func(path string) error {
+			if rcv.roots.Longest(path) != rcv.roots[i] {
+				//handled with the nested root
+				return filepath.SkipDir
+			}
+			if !now.done(i, path) {
+				//nor is anything after it
+				return errNotDone
+			}
+			if prev.done(i, path) {
+				if prev.Root == i && hasCleanPrefix(filepath.Clean(prev.Next), path) {
+					//the previous checkpoint was taken below it
+					return nil
+				}
 				return filepath.SkipDir
 			}
+			if !rcv.removedEntry(i, path) {
+				return nil
+			}
+			fc, err := rcv.Get(path)
+			if err != nil {
+				return err
+			}
+			if !rcv.excludes.entryExcluded(path, fc.Mode) {
+				rcv.cpRemoved = append(rcv.cpRemoved, fc)
+			}
 			return nil
+		})
+		if err != nil && err != errNotDone {
+			rcv.cpRemoved = rcv.cpRemoved[:n]
+			return err
 		}
 	}
-	if rcv.verbose && info.IsDir() {
-		fmt.Fprintf(rcv.console, "Entering %s\n", path)
+	rcv.cpDone = now
+	return nil
+}
+
+//removedEntry returns true if the DB entry at path below the i-th root was not found by the walk
+func (rcv *Comparator) removedEntry(i int, path string) bool {
+	if rcv.resume.done(i, path) {
+		return rcv.resumeGone.Has(path)
+	}
+	return !rcv.seen.Has(path) && !rcv.belowUnreadable(path)
+}
+
+//addUnreadable records that path could not be read
+func (rcv *Comparator) addUnreadable(path string) {
+	if !rcv.unreadableAt.Has(path) {
+		rcv.unreadableAt.Add(path)
+		rcv.unreadables = append(rcv.unreadables, path)
+	}
+}
+
+//interrupted records where the walk of the i-th root stopped and what was not covered because of it
+func (rcv *Comparator) interrupted(i int) {
+	rcv.stopRoot = i
+	rcv.run.Incomplete = true
+	root, dir := rcv.roots[i], rcv.run.InterruptedAt
+	if dir == "" {
+		dir = root
+	}
+	if dir != root {
+		dir = filepath.Dir(dir)
+	}
+	for {
+		rcv.run.NotCovered = append(rcv.run.NotCovered, dir)
+		if dir == root || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	rcv.run.NotCovered = append(rcv.run.NotCovered, rcv.roots[i+1:]...)
+}
+
+//covered returns true if path was reached by the walk, that is always unless it was interrupted
+func (rcv *Comparator) covered(path string) bool {
+	if !rcv.run.Incomplete {
+		return true
 	}
-	fc := &FileCheckInfo{
-		Path: path,
+	root := rcv.roots.Longest(path)
+	for i, v := range rcv.roots {
+		if v == root {
+			if i != rcv.stopRoot {
+				return i < rcv.stopRoot
+			}
+			return walkBefore(path, rcv.run.InterruptedAt)
+		}
+	}
+	return false
+}
+
+//Walk is the implemention of filepath.WalkFunc meant to be passed to filepath.Walk
+func (rcv *Comparator) Walk(path string, info os.FileInfo, err error) error {
+	if cerr := rcv.ctx.Err(); cerr != nil {
+		rcv.run.InterruptedAt = path
+		return cerr
+	}
+	if skip, serr := rcv.resume.skip(rcv.curRoot, path, info); skip {
+		return serr
+	}
+	if rcv.timer.due() {
+		rcv.checkpoint(path)
+	}
+	if excluded, skip := rcv.excludes.excluded(path, info); excluded {
+		return skip
 	}
 	if err != nil {
 		if os.IsNotExist(err) {
+			//vanished while walking, it will be reported as removed
 			return nil
 		}
-	} else {
-		fc.Size = info.Size()
-		fc.Mode = info.Mode()
-		fc.ModTime = info.ModTime()
+		log.Printf("Trouble in Comparator.Walk: %s\n", err)
+		rcv.errCount++
+		rcv.seen.Add(path)
+		rcv.addUnreadable(path)
+		if info != nil {
+			//a directory that could not be read, its own metadata is still compared (the worker marks the entry done)
+			rcv.run.Checked++
+			rcv.inflight.Add(1)
+			rcv.taskCh <- compareTask{NewFileCheckInfo(path, info), false}
+		} else {
+			rcv.progress.entryDone()
+		}
+		rcv.findingCh <- &Finding{Kind: KindUnreadable, Path: path, Error: err.Error()}
+		return nil
 	}
-	rcv.taskCh <- fc
+	if info.IsDir() {
+		rcv.progress.enter(path)
+		if rcv.verbose {
+			fmt.Fprintf(rcv.console, "Entering %s\n", path)
+		}
+	}
+	rcv.run.Checked++
+	rcv.seen.Add(path)
+	hash := true
+	if info.Mode().IsRegular() && rcv.policy.Attrs(path, info.Mode())&AttrContent != 0 {
+		hash = rcv.selector.take(rcv.curRoot, path, info.Size())
+	}
+	rcv.inflight.Add(1)
+	rcv.taskCh <- compareTask{NewFileCheckInfo(path, info), hash}
 	return nil
 }
 
-func (rcv *Comparator) compareFc(fc *FileCheckInfo) {
+//compareTask is an entry for the compare workers, hash is false if its content is not to be rehashed
+type compareTask struct {
+	fc   *FileCheckInfo
+	hash bool
+}
+
+//contentChecked counts a file whose content was (or due to the HashBudget was not) compared
+func (rcv *Comparator) contentChecked(hashed bool) {
+	rcv.l.Lock()
+	defer rcv.l.Unlock()
+	if hashed {
+		rcv.run.ContentChecked++
+	} else {
+		rcv.run.ContentSkipped++
+	}
+}
+
+//belowUnreadable returns true if any parent directory of path could not be read
+func (rcv *Comparator) belowUnreadable(path string) bool {
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if rcv.unreadableAt.Has(dir) {
+			return true
+		}
+		if next := filepath.Dir(dir); next == dir {
+			return false
+		}
+	}
+}
+
+func (rcv *Comparator) compareFc(fc *FileCheckInfo, hash bool) {
 	old, err := rcv.Get(fc.Path)
 	if err == ErrNotFound {
 		old = nil
 	} else if err != nil {
-		log.Fatalf("Trouble with Get(\"%s\") %s\n", fc.Path, err.Error())
+		log.Printf("Trouble with Get(\"%s\") %s\n", fc.Path, err.Error())
+		rcv.l.Lock()
+		if rcv.dbErr == nil {
+			rcv.dbErr = err
+		}
+		rcv.l.Unlock()
+		return
 	}
 	if old == nil {
-		//ok does not exist in db stop right here
-		rcv.newCh <- fc.Path
+		//ok does not exist in db, hash it only if it might have been moved or copied
+		if rcv.detectMoves {
+			if err := fc.CalcDigest(); err != nil {
+				log.Printf("Trouble calculating digest: %s\n", err.Error())
+			} else if len(fc.Digest) > 0 {
+				rcv.progress.hashed(fc.Size)
+			}
+		}
+		bad := rcv.checkIOC(fc, true)
+		rcv.findingCh <- rcv.annotateContent(&Finding{Kind: KindNew, Path: fc.Path, New: fc, knownBad: bad})
 		return
 	}
+	fc.Attrs = rcv.attrs(fc, old)
+	changes := fc.Diff(old, fc.Attrs&^AttrContent)
 	//to save time only calc digest if not obviously different
-	if fc.LiteMatch(old) {
+	contentCheck := len(changes) == 0 && fc.Attrs&AttrContent != 0 && fc.Mode.IsRegular()
+	var bad bool
+	if contentCheck && !hash {
+		//left for a later run by the HashBudget, what the DB has is matched
+		rcv.contentChecked(false)
+		rec := *fc
+		rec.Digest = old.Digest
+		bad = rcv.checkIOC(&rec, false)
+	} else if contentCheck {
 		if err := fc.CalcDigest(); err != nil {
 			log.Printf("Trouble calculating digest: %s\n", err.Error())
+			rcv.findingCh <- &Finding{Kind: KindUnreadable, Path: fc.Path, Error: err.Error(), Old: old, New: fc}
+			return
+		}
+		rcv.progress.hashed(fc.Size)
+		rcv.contentChecked(true)
+		if !bytes.Equal(fc.Digest, old.Digest) {
+			changes = append(changes, "digest")
+		}
+		bad = rcv.checkIOC(fc, true)
+	} else if hash && len(changes) > 0 && fc.Attrs&AttrEntropy != 0 && fc.Mode.IsRegular() {
+		//obviously different, but whether the content turned random tells an update from an encryption
+		if err := fc.CalcDigest(); err != nil {
+			log.Printf("Trouble calculating digest: %s\n", err.Error())
+		} else {
+			rcv.progress.hashed(fc.Size)
+		}
+		bad = rcv.checkIOC(fc, true)
+	} else {
+		bad = rcv.checkIOC(fc, hash)
+	}
+	if fc.Attrs&AttrEntropy != 0 && len(changes) > 0 && entropyJumped(old, fc) {
+		changes = append(changes, "entropy")
+	}
+	if fc.Attrs&AttrGrowing != 0 && len(old.PrefixDigest) > 0 && !hasChange(changes, "rotated", "shrunk") {
+		//a growing file may only have been appended to
+		if err := fc.CalcPrefixDigest(old.PrefixLen); err != nil {
+			log.Printf("Trouble calculating prefix digest: %s\n", err.Error())
+			rcv.findingCh <- &Finding{Kind: KindUnreadable, Path: fc.Path, Error: err.Error(), Old: old, New: fc}
+			return
+		}
+		rcv.progress.hashed(fc.PrefixLen)
+		if fc.PrefixLen < old.PrefixLen {
+			changes = append(changes, "shrunk")
+		} else if !bytes.Equal(fc.PrefixDigest, old.PrefixDigest) {
+			changes = append(changes, "rewritten")
 		}
 	}
-	if !fc.Match(old) {
-		rcv.changedCh <- fc.Path
+	if len(changes) > 0 {
+		rcv.findingCh <- rcv.annotateContent(&Finding{Kind: KindChanged, Path: fc.Path, Changes: changes, Old: old, New: fc, knownBad: bad})
+	}
+}
+
+//annotateContent passes f to the ContentAnnotators and returns it, reading files is left to the compare workers
+func (rcv *Comparator) annotateContent(f *Finding) *Finding {
+	for _, a := range rcv.annotators {
+		if ca, ok := a.(ContentAnnotator); ok {
+			ca.AnnotateContent(f)
+		}
+	}
+	return f
+}
+
+//checkIOC reports fc and returns true if its content is on the known-bad hash list, read tells if the file may be read for it
+func (rcv *Comparator) checkIOC(fc *FileCheckInfo, read bool) bool {
+	label, ok, err := rcv.iocs.Match(fc, read)
+	if err != nil {
+		log.Printf("Trouble matching %s against hash lists: %s\n", fc.Path, err)
+	} else if ok {
+		rcv.findingCh <- &Finding{Kind: KindIOC, Path: fc.Path, New: fc, Indicator: label}
+	}
+	return ok
+}
+
+//hasChange returns true if changes contain any of names
+func hasChange(changes []string, names ...string) bool {
+	for _, c := range changes {
+		for _, n := range names {
+			if c == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//attrs returns the attributes to check on fc, those the policy asks for that were recorded in old
+//the content is checked with the checksum old has, whichever the policy names
+func (rcv *Comparator) attrs(fc, old *FileCheckInfo) Attr {
+	return rcv.policy.Attrs(fc.Path, fc.Mode).anyDigest() & old.Attrs
+}
+
+//addFinding assigns severity to the finding, records it and passes it on to the reporter
+func (rcv *Comparator) addFinding(f *Finding) {
+	rcv.assess(f)
+	if rcv.mass != nil {
+		rcv.mass.finding(f)
+	}
+	if f.Severity < rcv.minSeverity && f.revises == "" {
+		rcv.suppress(f)
+	} else {
+		rcv.report(f)
+	}
+	if rcv.timer.enabled() {
+		//suppressed ones too, a resumed run counts them again and passes them on to a suppressedReporter
+		rcv.recorded = append(rcv.recorded, f)
+	}
+}
+
+//suppress counts f as left out of the report, a reporter taking suppressed findings still gets it
+func (rcv *Comparator) suppress(f *Finding) {
+	rcv.run.Suppressed++
+	if sr, ok := rcv.reporter.(suppressedReporter); ok {
+		if err := sr.Suppressed(f); err != nil {
+			log.Printf("Trouble writing report: %s\n", err)
+		}
+	}
+}
+
+//addPending reports the new file f right away, it is revised once it turns out to be moved or copied (see reportMoves)
+func (rcv *Comparator) addPending(f *Finding) {
+	rcv.pendingNew = append(rcv.pendingNew, f)
+	rcv.assess(f)
+	if f.Severity >= rcv.minSeverity {
+		rcv.report(f)
+	}
+}
+
+//assess annotates f and assigns its severity
+func (rcv *Comparator) assess(f *Finding) {
+	for _, a := range rcv.annotators {
+		a.Annotate(f)
+	}
+	f.Expected = ""
+	//suspicious and known-bad files are never expected
+	if len(f.Suspicious) == 0 && !f.knownBad {
+		if label, ok := rcv.allow.Match(f, true); ok {
+			f.Expected = label
+			f.Severity = SeverityInfo
+			return
+		}
+	}
+	f.Severity = rcv.policy.Severity(f)
+}
+
+//report lists f and passes it on to the reporter, a revised finding is no longer listed as what it was reported as
+func (rcv *Comparator) report(f *Finding) {
+	if l := rcv.kindList(f.revises); l != nil {
+		for i := len(*l) - 1; i >= 0; i-- {
+			if (*l)[i] == f.Path {
+				*l = append((*l)[:i], (*l)[i+1:]...)
+				break
+			}
+		}
+	}
+	if l := rcv.kindList(f.listedAs()); l != nil {
+		*l = append(*l, f.Path)
+	}
+	if err := rcv.reporter.Finding(f); err != nil {
+		log.Printf("Trouble writing report: %s\n", err)
+	}
+}
+
+//kindList returns the list of paths findings listed as kind are recorded in
+func (rcv *Comparator) kindList(kind string) *[]string {
+	switch kind {
+	case KindExpected:
+		return &rcv.expected
+	case KindNew:
+		return &rcv.newFiles
+	case KindChanged:
+		return &rcv.changedFiles
+	case KindRemoved:
+		return &rcv.removedFiles
+	case KindMoved:
+		return &rcv.movedFiles
+	case KindCopied:
+		return &rcv.copiedFiles
+	case KindUnreadable:
+		return &rcv.unreadable
+	case KindIOC:
+		return &rcv.iocFiles
+	case KindMassChange:
+		return &rcv.massChanges
+	}
+	return nil
+}
+
+//WalkErrors returns the number of filesystem entries that could not be walked
+func (rcv *Comparator) WalkErrors() int {
+	return rcv.errCount
+}
+
+//Counts returns the number of findings of each kind, known bad files only when checking against a hash list
+//expected files only when checking with an allowlist and mass changes only when watching for them
+func (rcv *Comparator) Counts() map[string]int {
+	counts := map[string]int{
+		KindNew:        len(rcv.newFiles),
+		KindChanged:    len(rcv.changedFiles),
+		KindRemoved:    len(rcv.removedFiles),
+		KindMoved:      len(rcv.movedFiles),
+		KindCopied:     len(rcv.copiedFiles),
+		KindUnreadable: len(rcv.unreadable),
+	}
+	if rcv.iocs != nil {
+		counts[KindIOC] = len(rcv.iocFiles)
+	}
+	if rcv.allow != nil {
+		counts[KindExpected] = len(rcv.expected)
+	}
+	if rcv.mass != nil {
+		counts[KindMassChange] = len(rcv.massChanges)
+	}
+	return counts
+}
+
+//reportMoves matches the held back new files against DB entries with the same digest
+//a match with a removed entry is a move, a match with a still existing one is a copy
+//removed entries that were not moved are reported as removed
+func (rcv *Comparator) reportMoves(digests *DigestIndex, removed []*FileCheckInfo) {
+	gone := make(map[string]*FileCheckInfo, len(removed))
+	for _, fc := range removed {
+		gone[fc.Path] = fc
+	}
+	moved := make(StringSet)
+	for _, f := range rcv.pendingNew {
+		reported, listed := f.Severity >= rcv.minSeverity, f.listedAs()
+		var copyOf string
+		for _, p := range digests.Lookup(f.New.Digest) {
+			if old, ok := gone[p]; ok && !moved.Has(p) {
+				moved.Add(p)
+				f.Kind = KindMoved
+				f.From = p
+				f.Old = old
+				f.Changes = f.New.Diff(old, rcv.attrs(f.New, old))
+				break
+			} else if !ok && copyOf == "" {
+				copyOf = p
+			}
+		}
+		if f.Kind == KindNew && copyOf != "" {
+			f.Kind = KindCopied
+			f.From = copyOf
+		}
+		if f.Kind == KindNew {
+			//it was reported as it is
+			if rcv.mass != nil {
+				rcv.mass.finding(f)
+			}
+			if !reported {
+				rcv.suppress(f)
+			}
+			continue
+		}
+		if reported {
+			f.revises = listed
+		}
+		rcv.addFinding(f)
+	}
+	rcv.pendingNew = nil
+	for _, fc := range removed {
+		if !moved.Has(fc.Path) {
+			rcv.addFinding(&Finding{Kind: KindRemoved, Path: fc.Path, Old: fc})
+		}
 	}
 }
 
 //Stop is a wrapper around underlying DB.Stop that also prints the final report of comparison
-func (rcv *Comparator) Stop() error {
-	defer rcv.FileInfoReader.Stop()
+//after an interrupted walk the report is marked incomplete and lists what was not covered
+func (rcv *Comparator) Stop() (err error) {
+	defer func() {
+		if serr := rcv.FileInfoReader.Stop(); err == nil {
+			err = serr
+		}
+	}()
 	//wait for compare tasks to finish
 	for i := 0; i < rcv.numWorkers; i++ {
 		rcv.quitCh <- true
@@ -150,38 +821,62 @@ func (rcv *Comparator) Stop() error {
 	close(rcv.doneCh)
 	//this one is for the appender routine
 	rcv.quitCh <- true
-	//find the deleted files
-	maperror := rcv.Map(rcv.pathWalked, func(fc *FileCheckInfo) error {
-		for _, v := range rcv.excludes {
-			if strings.HasPrefix(fc.Path, v) {
+	//find the deleted files, that is DB entries that were not seen during the walk
+	var removed []*FileCheckInfo
+	digests := NewDigestIndex()
+	var maperror error
+	for i, root := range rcv.roots {
+		if rcv.run.Incomplete && i > rcv.stopRoot {
+			//not walked at all
+			break
+		}
+		maperror = rcv.Map(root, func(fc *FileCheckInfo) error {
+			if rcv.roots.Longest(fc.Path) != root || !rcv.covered(fc.Path) {
+				//handled with the nested root or not reached before the walk was interrupted
+				return nil
+			}
+			if rcv.excludes.entryExcluded(fc.Path, fc.Mode) {
 				//path is excluded
 				return nil
 			}
-		}
-		if _, perr := os.Lstat(fc.Path); perr != nil {
-			if os.IsNotExist(perr) {
-				rcv.removedFiles = append(rcv.removedFiles, fc.Path)
-			} else {
-				log.Printf("Error in Map step: %s", perr)
+			if len(rcv.pendingNew) > 0 {
+				digests.Add(fc)
 			}
+			if rcv.mass != nil {
+				rcv.mass.entry(fc)
+			}
+			if rcv.removedEntry(i, fc.Path) {
+				removed = append(removed, fc)
+			}
+			return nil
+		})
+		if maperror != nil {
+			log.Printf("Error in Map: %s", maperror.Error())
+			break
+		}
+	}
+	rcv.reportMoves(digests, removed)
+	if rcv.mass != nil {
+		for _, f := range rcv.mass.alerts() {
+			rcv.addFinding(f)
 		}
-		return nil
-	})
-	if maperror != nil {
-		log.Printf("Error in Map: %s", maperror.Error())
 	}
+	cursor, cerr := rcv.selector.saveCursor(!rcv.run.Incomplete)
+	if cerr != nil {
+		log.Printf("Trouble saving hash cursor: %s\n", cerr)
+	}
+	rcv.run.Cursor = cursor
 	//Print the report
-	fmt.Fprintf(rcv.console, "\n\nChanged files %d\n\n", len(rcv.changedFiles))
-	for _, v := range rcv.changedFiles {
-		fmt.Fprintln(rcv.console, v)
+	rcv.run.Finished = time.Now()
+	if err = rcv.reporter.End(&rcv.run); err != nil {
+		return err
 	}
-	fmt.Fprintf(rcv.console, "\n\nNew files %d\n\n", len(rcv.newFiles))
-	for _, v := range rcv.newFiles {
-		fmt.Fprintln(rcv.console, v)
+	if rcv.dbErr != nil {
+		return rcv.dbErr
 	}
-	fmt.Fprintf(rcv.console, "\n\nDeleted files %d\n\n", len(rcv.removedFiles))
-	for _, v := range rcv.removedFiles {
-		fmt.Fprintln(rcv.console, v)
+	if maperror == nil && !rcv.run.Incomplete && rcv.timer.enabled() {
+		//nothing left to resume
+		os.Remove(rcv.timer.file)
 	}
-	return nil
+	return maperror
 }
@@ -0,0 +1,654 @@
+package fcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+//scratch is a directory the walkers are run over and the DB generated of it, the suites running them embed it
+type scratch struct {
+	root   string
+	dbName string
+}
+
+func (s *scratch) setUp(c *C) {
+	s.root = c.MkDir()
+	s.dbName = filepath.Join(c.MkDir(), "fcheck_test.db")
+}
+
+func (s *scratch) write(c *C, name, content string) {
+	s.writeMode(c, name, content, 0644)
+}
+
+func (s *scratch) writeMode(c *C, name, content string, mode os.FileMode) {
+	p := filepath.Join(s.root, name)
+	c.Assert(os.MkdirAll(filepath.Dir(p), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(p, []byte(content), mode), IsNil)
+}
+
+//generate generates the DB of root, setup configures the Generator before it walks
+func (s *scratch) generate(c *C, setup ...func(g *Generator)) *Generator {
+	g := NewGenerator(s.dbName, 2, false)
+	for _, f := range setup {
+		f(g)
+	}
+	c.Assert(g.Start(), IsNil)
+	c.Assert(g.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+	c.Assert(g.Stop(), IsNil)
+	return g
+}
+
+//compare compares root with the DB and returns the Comparator along with its report, setup configures it before it walks
+func (s *scratch) compare(c *C, setup ...func(cm *Comparator)) (*Comparator, *bytes.Buffer) {
+	var buf bytes.Buffer
+	cm := NewComparator(s.dbName, 2, false)
+	cm.console = &buf
+	for _, f := range setup {
+		f(cm)
+	}
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+	c.Assert(cm.Stop(), IsNil)
+	return cm, &buf
+}
+
+//ComparatorSuite runs the generator and comparator over a scratch directory
+type ComparatorSuite struct {
+	scratch
+}
+
+var _ = Suite(&ComparatorSuite{})
+
+func (s *ComparatorSuite) SetUpTest(c *C) {
+	s.setUp(c)
+}
+
+func (s *ComparatorSuite) TestMovesAndCopies(c *C) {
+	s.write(c, "bin/foo", "foo binary")
+	s.write(c, "bin/bar", "bar binary")
+	s.write(c, "etc/conf", "setting=1")
+	s.generate(c)
+	c.Assert(os.MkdirAll(filepath.Join(s.root, "local/bin"), 0755), IsNil)
+	c.Assert(os.Rename(filepath.Join(s.root, "bin/foo"), filepath.Join(s.root, "local/bin/foo")), IsNil)
+	s.write(c, "local/bin/bar", "bar binary")
+	s.write(c, "etc/other", "something else")
+	cm, buf := s.compare(c)
+	c.Assert(cm.movedFiles, DeepEquals, []string{filepath.Join(s.root, "local/bin/foo")})
+	c.Assert(cm.copiedFiles, DeepEquals, []string{filepath.Join(s.root, "local/bin/bar")})
+	c.Assert(cm.removedFiles, HasLen, 0)
+	sort.Strings(cm.newFiles)
+	c.Assert(cm.newFiles, DeepEquals, []string{filepath.Join(s.root, "etc/other"), filepath.Join(s.root, "local"), filepath.Join(s.root, "local/bin")})
+	c.Assert(bytes.Contains(buf.Bytes(), []byte(filepath.Join(s.root, "bin/foo")+" -> "+filepath.Join(s.root, "local/bin/foo"))), Equals, true)
+}
+
+func (s *ComparatorSuite) TestMovesRevised(c *C) {
+	s.write(c, "a", "content")
+	s.generate(c)
+	c.Assert(os.Rename(filepath.Join(s.root, "a"), filepath.Join(s.root, "b")), IsNil)
+	var buf bytes.Buffer
+	rep, err := NewReporter(FormatNDJSON, &buf)
+	c.Assert(err, IsNil)
+	cm := NewComparator(s.dbName, 2, false)
+	cm.SetReporter(rep)
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+	c.Assert(cm.Stop(), IsNil)
+	var events []string
+	counts := make(map[string]int)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var ev struct {
+			Event  string
+			Kind   string
+			Path   string
+			Counts map[string]int
+		}
+		c.Assert(json.Unmarshal([]byte(line), &ev), IsNil)
+		if ev.Path == filepath.Join(s.root, "b") {
+			events = append(events, ev.Event+":"+ev.Kind)
+		}
+		if ev.Event == "end" {
+			counts = ev.Counts
+		}
+	}
+	//streamed as new, revised once the removed file is known
+	c.Assert(events, DeepEquals, []string{"finding:" + KindNew, "revised:" + KindMoved})
+	c.Assert(counts[KindNew], Equals, 0)
+	c.Assert(counts[KindMoved], Equals, 1)
+	c.Assert(cm.newFiles, HasLen, 0)
+	c.Assert(cm.movedFiles, DeepEquals, []string{filepath.Join(s.root, "b")})
+}
+
+func (s *ComparatorSuite) TestNoMoveDetection(c *C) {
+	s.write(c, "a", "content")
+	s.generate(c)
+	c.Assert(os.Rename(filepath.Join(s.root, "a"), filepath.Join(s.root, "b")), IsNil)
+	var buf bytes.Buffer
+	cm := NewComparator(s.dbName, 2, false)
+	cm.console = &buf
+	cm.SetDetectMoves(false)
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+	c.Assert(cm.Stop(), IsNil)
+	c.Assert(cm.movedFiles, HasLen, 0)
+	c.Assert(cm.newFiles, DeepEquals, []string{filepath.Join(s.root, "b")})
+	c.Assert(cm.removedFiles, DeepEquals, []string{filepath.Join(s.root, "a")})
+}
+
+func (s *ComparatorSuite) TestRemovedFromWalkSet(c *C) {
+	s.write(c, "a/one", "1")
+	s.write(c, "a/two", "2")
+	s.write(c, "ab/three", "3")
+	s.generate(c)
+	c.Assert(os.Remove(filepath.Join(s.root, "a/two")), IsNil)
+	var buf bytes.Buffer
+	cm := NewComparator(s.dbName, 2, false)
+	cm.console = &buf
+	c.Assert(cm.Start(), IsNil)
+	//sibling directory ab shares the prefix but must not be reported as removed
+	c.Assert(cm.StartWalking(context.Background(), []string{filepath.Join(s.root, "a")}, NewMatcher()), IsNil)
+	c.Assert(cm.Stop(), IsNil)
+	c.Assert(cm.removedFiles, DeepEquals, []string{filepath.Join(s.root, "a/two")})
+	c.Assert(cm.unreadable, HasLen, 0)
+}
+
+func (s *ComparatorSuite) TestUnreadable(c *C) {
+	if os.Geteuid() == 0 {
+		c.Skip("permissions are not enforced for root")
+	}
+	s.write(c, "locked/secret", "s")
+	s.write(c, "locked/sub/deeper", "d")
+	s.write(c, "open", "o")
+	s.generate(c)
+	locked := filepath.Join(s.root, "locked")
+	c.Assert(os.Chmod(locked, 0), IsNil)
+	defer os.Chmod(locked, 0755)
+	cm, _ := s.compare(c)
+	c.Assert(cm.unreadable, DeepEquals, []string{locked})
+	//the directory itself is still compared
+	c.Assert(cm.changedFiles, DeepEquals, []string{locked})
+	c.Assert(cm.removedFiles, HasLen, 0)
+	c.Assert(cm.WalkErrors(), Equals, 1)
+}
+
+func (s *ComparatorSuite) TestExcludeRules(c *C) {
+	s.write(c, "log/syslog", "a")
+	s.write(c, "log/secure", "b")
+	s.write(c, "keep", "c")
+	s.generate(c)
+	s.write(c, "log/syslog", "grown")
+	s.write(c, "log/secure", "tampered")
+	c.Assert(os.Remove(filepath.Join(s.root, "keep")), IsNil)
+	m := NewMatcher()
+	c.Assert(m.Add(filepath.Join(s.root, "log")), IsNil)
+	c.Assert(m.Add("!"+filepath.Join(s.root, "log/secure")), IsNil)
+	var buf bytes.Buffer
+	cm := NewComparator(s.dbName, 2, false)
+	cm.console = &buf
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(context.Background(), []string{s.root}, m), IsNil)
+	c.Assert(cm.Stop(), IsNil)
+	//the root directory changed because keep was removed
+	sort.Strings(cm.changedFiles)
+	c.Assert(cm.changedFiles, DeepEquals, []string{s.root, filepath.Join(s.root, "log/secure")})
+	c.Assert(cm.removedFiles, DeepEquals, []string{filepath.Join(s.root, "keep")})
+}
+
+func (s *ComparatorSuite) TestPolicy(c *C) {
+	s.write(c, "etc/conf", "setting=1")
+	s.write(c, "home/notes", "todo")
+	s.write(c, "log/syslog", "line 1\n")
+	//the last matching selector applies
+	p, err := ParsePolicy(strings.NewReader(s.root + " p\n" + s.root + "/home p+u+g\n" + s.root + "/log >\n"))
+	c.Assert(err, IsNil)
+	p.Add(s.root + "/etc R")
+	g := NewGenerator(s.dbName, 2, false)
+	g.SetPolicy(p)
+	c.Assert(g.Start(), IsNil)
+	c.Assert(g.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+	c.Assert(g.Stop(), IsNil)
+	//content changes are ignored under home, appends are fine for logs
+	s.write(c, "home/notes", "todo: something else")
+	s.write(c, "log/syslog", "line 1\nline 2\n")
+	s.write(c, "etc/conf", "setting=2")
+	var buf bytes.Buffer
+	cm := NewComparator(s.dbName, 2, false)
+	cm.console = &buf
+	cm.SetPolicy(p)
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+	c.Assert(cm.Stop(), IsNil)
+	c.Assert(cm.changedFiles, DeepEquals, []string{filepath.Join(s.root, "etc/conf")})
+	//shrinking a log is a change
+	s.write(c, "log/syslog", "")
+	buf.Reset()
+	cm = NewComparator(s.dbName, 2, false)
+	cm.console = &buf
+	cm.SetPolicy(p)
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(context.Background(), []string{filepath.Join(s.root, "log")}, NewMatcher()), IsNil)
+	c.Assert(cm.Stop(), IsNil)
+	c.Assert(cm.changedFiles, DeepEquals, []string{filepath.Join(s.root, "log/syslog")})
+}
+
+func (s *ComparatorSuite) TestGrowingLog(c *C) {
+	s.write(c, "log/syslog", "line 1\n")
+	s.write(c, "log/auth", "login\n")
+	s.write(c, "log/messages", "hello\n")
+	p := NewPolicy()
+	c.Assert(p.Add(s.root+"/log >"), IsNil)
+	g := NewGenerator(s.dbName, 2, false)
+	g.SetPolicy(p)
+	c.Assert(g.Start(), IsNil)
+	c.Assert(g.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+	c.Assert(g.Stop(), IsNil)
+	//appended to
+	f, err := os.OpenFile(filepath.Join(s.root, "log/syslog"), os.O_APPEND|os.O_WRONLY, 0)
+	c.Assert(err, IsNil)
+	f.WriteString("line 2\n")
+	f.Close()
+	//rewritten in place and grown
+	f, err = os.OpenFile(filepath.Join(s.root, "log/auth"), os.O_WRONLY, 0)
+	c.Assert(err, IsNil)
+	f.WriteString("LOGIN\nlogout\n")
+	f.Close()
+	//rotated without being configured
+	c.Assert(os.Rename(filepath.Join(s.root, "log/messages"), filepath.Join(s.root, "messages.1")), IsNil)
+	s.write(c, "log/messages", "hello again, a longer line\n")
+	cm := NewComparator(s.dbName, 2, false)
+	cm.console = &bytes.Buffer{}
+	rep := &JSONReporter{out: &bytes.Buffer{}}
+	cm.SetReporter(rep)
+	cm.SetPolicy(p)
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(context.Background(), []string{filepath.Join(s.root, "log")}, NewMatcher()), IsNil)
+	c.Assert(cm.Stop(), IsNil)
+	changes := make(map[string][]string)
+	for _, f := range rep.byKind[KindChanged] {
+		changes[filepath.Base(f.Path)] = f.Changes
+	}
+	c.Assert(changes, DeepEquals, map[string][]string{
+		"auth":     {"rewritten"},
+		"messages": {"inode", "rotated"},
+	})
+}
+
+func (s *ComparatorSuite) TestMinSeverity(c *C) {
+	s.write(c, "etc/conf", "setting=1")
+	s.write(c, "bin/tool", "binary")
+	s.generate(c)
+	s.write(c, "etc/conf", "setting=2")
+	s.write(c, "bin/tool", "trojan")
+	p, err := ParsePolicy(strings.NewReader("severity=low " + s.root + "\nseverity=critical " + s.root + "/bin\n"))
+	c.Assert(err, IsNil)
+	var buf bytes.Buffer
+	cm := NewComparator(s.dbName, 2, false)
+	cm.console = &buf
+	cm.SetPolicy(p)
+	cm.SetMinSeverity(SeverityHigh)
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+	c.Assert(cm.Stop(), IsNil)
+	c.Assert(cm.changedFiles, DeepEquals, []string{filepath.Join(s.root, "bin/tool")})
+	//etc/conf and the directories it changed
+	c.Assert(cm.run.Suppressed > 0, Equals, true)
+	c.Assert(strings.Contains(buf.String(), "critical "+filepath.Join(s.root, "bin/tool")), Equals, true)
+}
+
+func (s *ComparatorSuite) TestMultipleRoots(c *C) {
+	s.write(c, "etc/conf", "setting=1")
+	s.write(c, "usr/bin/tool", "binary")
+	s.write(c, "home/notes", "todo")
+	etc, usr := filepath.Join(s.root, "etc"), filepath.Join(s.root, "usr")
+	g := NewGenerator(s.dbName, 2, false)
+	c.Assert(g.Start(), IsNil)
+	//nested roots are walked once
+	c.Assert(g.StartWalking(context.Background(), []string{usr, etc, filepath.Join(usr, "bin")}, NewMatcher()), IsNil)
+	c.Assert(g.Stop(), IsNil)
+	s.write(c, "home/notes", "todo: more")
+	c.Assert(os.Remove(filepath.Join(s.root, "usr/bin/tool")), IsNil)
+	var buf bytes.Buffer
+	cm := NewComparator(s.dbName, 2, false)
+	cm.console = &buf
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.Header(), NotNil)
+	c.Assert(cm.Header().Roots, DeepEquals, []string{etc, usr})
+	c.Assert(cm.StartWalking(context.Background(), cm.Header().Roots, NewMatcher()), IsNil)
+	c.Assert(cm.Stop(), IsNil)
+	//home was never recorded, it is neither new nor checked
+	c.Assert(cm.newFiles, HasLen, 0)
+	c.Assert(cm.removedFiles, DeepEquals, []string{filepath.Join(usr, "bin/tool")})
+	c.Assert(cm.changedFiles, DeepEquals, []string{filepath.Join(usr, "bin")})
+	c.Assert(cm.run.Roots, DeepEquals, []string{etc, usr})
+	c.Assert(NewGenerator(filepath.Join(c.MkDir(), "empty.db"), 1, false).StartWalking(context.Background(), nil, NewMatcher()), Equals, errNoRoots)
+}
+
+func (s *ComparatorSuite) TestDBWithoutHeader(c *C) {
+	s.write(c, "one", "1")
+	//DBs written by older versions start with the first record
+	f, err := os.Create(s.dbName)
+	c.Assert(err, IsNil)
+	info, err := os.Lstat(filepath.Join(s.root, "one"))
+	c.Assert(err, IsNil)
+	fc := NewFileCheckInfo(filepath.Join(s.root, "one"), info)
+	c.Assert(fc.CalcDigest(), IsNil)
+	_, err = encode(f, fc)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	d := NewDBReader(s.dbName)
+	c.Assert(d.Start(), IsNil)
+	c.Assert(d.Header(), IsNil)
+	c.Assert(d.GenerateIndex(), IsNil)
+	got, err := d.Get(fc.Path)
+	c.Assert(err, IsNil)
+	c.Assert(got.Match(fc), Equals, true)
+	c.Assert(d.Stop(), IsNil)
+}
+
+//cancelAfter is a context cancelled once Err has been asked n times, the walkers ask once per path
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (ctx *cancelAfter) Err() error {
+	if ctx.n <= 0 {
+		return context.Canceled
+	}
+	ctx.n--
+	return nil
+}
+
+func (s *ComparatorSuite) TestInterrupted(c *C) {
+	s.write(c, "a/1", "1")
+	s.write(c, "b/2", "2")
+	s.write(c, "c/3", "3")
+	s.generate(c)
+	c.Assert(os.Remove(filepath.Join(s.root, "a/1")), IsNil)
+	c.Assert(os.Remove(filepath.Join(s.root, "c/3")), IsNil)
+	var buf bytes.Buffer
+	cm := NewComparator(s.dbName, 2, false)
+	cm.console = &buf
+	c.Assert(cm.Start(), IsNil)
+	//root, a and b are walked, then it stops at b/2
+	ctx := &cancelAfter{context.Background(), 3}
+	c.Assert(cm.StartWalking(ctx, []string{s.root}, NewMatcher()), Equals, context.Canceled)
+	c.Assert(cm.Stop(), IsNil)
+	c.Assert(cm.run.Incomplete, Equals, true)
+	c.Assert(cm.run.InterruptedAt, Equals, filepath.Join(s.root, "b/2"))
+	c.Assert(cm.run.NotCovered, DeepEquals, []string{filepath.Join(s.root, "b"), s.root})
+	//c/3 was not reached, so it is not known to be removed
+	c.Assert(cm.removedFiles, DeepEquals, []string{filepath.Join(s.root, "a/1")})
+	c.Assert(strings.Contains(buf.String(), "INCOMPLETE: interrupted at "+filepath.Join(s.root, "b/2")), Equals, true)
+}
+
+func (s *ComparatorSuite) TestInterruptedGenerate(c *C) {
+	s.write(c, "one", "1")
+	s.generate(c)
+	s.write(c, "two", "2")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g := NewGenerator(s.dbName, 2, false)
+	c.Assert(g.Start(), IsNil)
+	c.Assert(g.StartWalking(ctx, []string{s.root}, NewMatcher()), Equals, context.Canceled)
+	c.Assert(g.Stop(), IsNil)
+	//the previous DB is kept
+	_, err := os.Stat(s.dbName + ".new")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	d := NewDBReader(s.dbName)
+	c.Assert(d.Start(), IsNil)
+	c.Assert(d.GenerateIndex(), IsNil)
+	_, err = d.Get(filepath.Join(s.root, "one"))
+	c.Assert(err, IsNil)
+	_, err = d.Get(filepath.Join(s.root, "two"))
+	c.Assert(err, Equals, ErrNotFound)
+	c.Assert(d.Stop(), IsNil)
+	//a signal arriving after the walk is complete does not discard the DB
+	ctx, cancel = context.WithCancel(context.Background())
+	g = NewGenerator(s.dbName, 2, false)
+	c.Assert(g.Start(), IsNil)
+	c.Assert(g.StartWalking(ctx, []string{s.root}, NewMatcher()), IsNil)
+	cancel()
+	c.Assert(g.Stop(), IsNil)
+	d = NewDBReader(s.dbName)
+	c.Assert(d.Start(), IsNil)
+	c.Assert(d.GenerateIndex(), IsNil)
+	_, err = d.Get(filepath.Join(s.root, "two"))
+	c.Assert(err, IsNil)
+	c.Assert(d.Stop(), IsNil)
+}
+
+func (s *ComparatorSuite) TestProgress(c *C) {
+	s.write(c, "a/1", "1")
+	s.write(c, "a/2", "22")
+	s.write(c, "b", "333")
+	s.generate(c)
+	s.write(c, "b", "4444")
+	var buf bytes.Buffer
+	p := NewProgress()
+	cm := NewComparator(s.dbName, 2, false)
+	cm.console = &buf
+	cm.SetProgress(p)
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+	c.Assert(cm.Stop(), IsNil)
+	pi := p.Snapshot()
+	//root, a, a/1, a/2 and b
+	c.Assert(pi.Total, Equals, int64(5))
+	c.Assert(pi.Entries, Equals, int64(5))
+	//only the unchanged files are hashed, b differs in size already
+	c.Assert(pi.Bytes, Equals, int64(3))
+}
+
+func (s *ComparatorSuite) TestResume(c *C) {
+	s.write(c, "a/1", "1")
+	s.write(c, "b/2", "2")
+	s.write(c, "c/3", "3")
+	s.write(c, "d/4", "4")
+	s.generate(c)
+	c.Assert(os.Remove(filepath.Join(s.root, "a/1")), IsNil)
+	s.write(c, "a/new", "new")
+	s.write(c, "c/3", "x")
+	c.Assert(os.Remove(filepath.Join(s.root, "d/4")), IsNil)
+	state := s.dbName + ".state"
+	var buf bytes.Buffer
+	cm := NewComparator(s.dbName, 2, false)
+	cm.console = &buf
+	cm.SetCheckpoint(state, time.Hour)
+	c.Assert(cm.Start(), IsNil)
+	//root, a, a/new and b are walked, then it stops at b/2
+	ctx := &cancelAfter{context.Background(), 4}
+	c.Assert(cm.StartWalking(ctx, []string{s.root}, NewMatcher()), Equals, context.Canceled)
+	c.Assert(cm.Stop(), IsNil)
+	cp, err := LoadCheckpoint(state)
+	c.Assert(err, IsNil)
+	c.Assert(cp.Next, Equals, filepath.Join(s.root, "b/2"))
+	c.Assert(cp.Removed, HasLen, 1)
+	c.Assert(cp.Removed[0].Path, Equals, filepath.Join(s.root, "a/1"))
+
+	buf.Reset()
+	cm = NewComparator(s.dbName, 2, false)
+	cm.console = &buf
+	cm.SetCheckpoint(state, time.Hour)
+	cm.Resume(cp)
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+	c.Assert(cm.Stop(), IsNil)
+	c.Assert(cm.run.Resumed, Equals, true)
+	//root, a, a/new, b, b/2, c, c/3 and d
+	c.Assert(cm.run.Checked, Equals, int64(8))
+	sort.Strings(cm.removedFiles)
+	c.Assert(cm.removedFiles, DeepEquals, []string{filepath.Join(s.root, "a/1"), filepath.Join(s.root, "d/4")})
+	c.Assert(cm.newFiles, DeepEquals, []string{filepath.Join(s.root, "a/new")})
+	//a was found changed before the checkpoint
+	sort.Strings(cm.changedFiles)
+	c.Assert(cm.changedFiles, DeepEquals, []string{filepath.Join(s.root, "a"), filepath.Join(s.root, "c/3"), filepath.Join(s.root, "d")})
+	//the run is complete, there is nothing left to resume
+	_, err = os.Stat(state)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *ComparatorSuite) TestResumeAppended(c *C) {
+	s.write(c, "a/1", "1")
+	s.write(c, "b/2", "2")
+	s.write(c, "c/3", "3")
+	s.generate(c)
+	c.Assert(os.Remove(filepath.Join(s.root, "a/1")), IsNil)
+	s.write(c, "a/new", "new")
+	s.write(c, "c/3", "x")
+	state := s.dbName + ".state"
+	var buf bytes.Buffer
+	cm := NewComparator(s.dbName, 2, false)
+	cm.console = &buf
+	//a checkpoint is appended with every entry walked
+	cm.SetCheckpoint(state, time.Nanosecond)
+	c.Assert(cm.Start(), IsNil)
+	ctx := &cancelAfter{context.Background(), 6}
+	c.Assert(cm.StartWalking(ctx, []string{s.root}, NewMatcher()), Equals, context.Canceled)
+	c.Assert(cm.Stop(), IsNil)
+	cp, err := LoadCheckpoint(state)
+	c.Assert(err, IsNil)
+	c.Assert(cp.Next, Equals, filepath.Join(s.root, "c/3"))
+	//each is saved once however many checkpoints there were after it was found
+	c.Assert(cp.Removed, HasLen, 1)
+	c.Assert(cp.Removed[0].Path, Equals, filepath.Join(s.root, "a/1"))
+	c.Assert(cp.Pending, HasLen, 1)
+	c.Assert(cp.Findings, HasLen, 1)
+
+	cm = NewComparator(s.dbName, 2, false)
+	cm.console = &buf
+	cm.SetCheckpoint(state, time.Nanosecond)
+	cm.Resume(cp)
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+	c.Assert(cm.Stop(), IsNil)
+	c.Assert(cm.removedFiles, DeepEquals, []string{filepath.Join(s.root, "a/1")})
+	c.Assert(cm.newFiles, DeepEquals, []string{filepath.Join(s.root, "a/new")})
+	sort.Strings(cm.changedFiles)
+	c.Assert(cm.changedFiles, DeepEquals, []string{filepath.Join(s.root, "a"), filepath.Join(s.root, "c/3")})
+}
+
+func (s *ComparatorSuite) TestResumeChangedDB(c *C) {
+	s.write(c, "a", "1")
+	s.write(c, "b", "2")
+	s.generate(c)
+	state := s.dbName + ".state"
+	cm := NewComparator(s.dbName, 2, false)
+	cm.console = &bytes.Buffer{}
+	cm.SetCheckpoint(state, time.Hour)
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(&cancelAfter{context.Background(), 2}, []string{s.root}, NewMatcher()), Equals, context.Canceled)
+	c.Assert(cm.Stop(), IsNil)
+	cp, err := LoadCheckpoint(state)
+	c.Assert(err, IsNil)
+	//the DB was regenerated in between
+	s.generate(c)
+	cm = NewComparator(s.dbName, 2, false)
+	cm.console = &bytes.Buffer{}
+	cm.Resume(cp)
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(context.Background(), []string{s.root}, NewMatcher()), ErrorMatches, ".* changed since the checkpoint was taken")
+	cm = NewComparator(s.dbName, 2, false)
+	cm.Resume(cp)
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(context.Background(), []string{filepath.Join(s.root, "a")}, NewMatcher()), ErrorMatches, "checkpoint was taken walking .*")
+}
+
+func (s *ComparatorSuite) TestResumeGenerate(c *C) {
+	s.write(c, "a/1", "1")
+	s.write(c, "b/2", "2")
+	s.write(c, "c/3", "3")
+	state := s.dbName + ".state"
+	g := NewGenerator(s.dbName, 2, false)
+	//a checkpoint at every path
+	g.SetCheckpoint(state, time.Nanosecond)
+	c.Assert(g.Start(), IsNil)
+	c.Assert(g.StartWalking(&cancelAfter{context.Background(), 4}, []string{s.root}, NewMatcher()), Equals, context.Canceled)
+	c.Assert(g.Stop(), IsNil)
+	//the incomplete DB is kept to be resumed
+	_, err := os.Stat(s.dbName)
+	c.Assert(os.IsNotExist(err), Equals, true)
+	cp, err := LoadCheckpoint(state)
+	c.Assert(err, IsNil)
+	c.Assert(cp.Next, Equals, filepath.Join(s.root, "b/2"))
+	s.write(c, "d/4", "4")
+	g = NewGenerator(s.dbName, 2, false)
+	g.SetCheckpoint(state, time.Hour)
+	g.Resume(cp)
+	c.Assert(g.Start(), IsNil)
+	c.Assert(g.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+	c.Assert(g.Stop(), IsNil)
+	_, err = os.Stat(state)
+	c.Assert(os.IsNotExist(err), Equals, true)
+	d := NewDBReader(s.dbName)
+	c.Assert(d.Start(), IsNil)
+	c.Assert(d.GenerateIndex(), IsNil)
+	c.Assert(d.Header().Created.Equal(cp.DB.Created), Equals, true)
+	//root, the 4 directories and the 4 files, each once
+	n := 0
+	c.Assert(d.Map(s.root, func(fc *FileCheckInfo) error {
+		n++
+		return nil
+	}), IsNil)
+	c.Assert(n, Equals, 9)
+	c.Assert(d.Stop(), IsNil)
+	//a checkpoint of a generate run can not be resumed by a check
+	cm := NewComparator(s.dbName, 2, false)
+	cm.Resume(cp)
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(context.Background(), []string{s.root}, NewMatcher()), ErrorMatches, "checkpoint is of a generate run, not check")
+}
+
+func (s *ComparatorSuite) TestHashBudget(c *C) {
+	for _, name := range []string{"a", "b", "c", "d"} {
+		s.write(c, name, "1111111111")
+	}
+	//only size and content, so that rewriting the files leaves just the digest to tell
+	p, err := ParsePolicy(strings.NewReader(s.root + " s+sha512\n"))
+	c.Assert(err, IsNil)
+	g := NewGenerator(s.dbName, 2, false)
+	g.SetPolicy(p)
+	c.Assert(g.Start(), IsNil)
+	c.Assert(g.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+	c.Assert(g.Stop(), IsNil)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		s.write(c, name, "2222222222")
+	}
+	budget := &HashBudget{Bytes: 20, CursorFile: s.dbName + ".cursor"}
+	check := func() *Comparator {
+		var buf bytes.Buffer
+		cm := NewComparator(s.dbName, 2, false)
+		cm.console = &buf
+		cm.SetPolicy(p)
+		cm.SetHashBudget(budget)
+		c.Assert(cm.Start(), IsNil)
+		c.Assert(cm.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+		c.Assert(cm.Stop(), IsNil)
+		sort.Strings(cm.changedFiles)
+		return cm
+	}
+	cm := check()
+	c.Assert(cm.changedFiles, DeepEquals, []string{filepath.Join(s.root, "a"), filepath.Join(s.root, "b")})
+	c.Assert(cm.run.ContentCoverage(), Equals, "2 of 4 files (50.0%)")
+	c.Assert(cm.run.Cursor, Equals, filepath.Join(s.root, "b"))
+	//the next run continues after the cursor and wraps around once it reaches the end
+	cm = check()
+	c.Assert(cm.changedFiles, DeepEquals, []string{filepath.Join(s.root, "c"), filepath.Join(s.root, "d")})
+	c.Assert(cm.run.Cursor, Equals, "")
+	cm = check()
+	c.Assert(cm.changedFiles, DeepEquals, []string{filepath.Join(s.root, "a"), filepath.Join(s.root, "b")})
+	//a random sample instead
+	budget = &HashBudget{Sample: 0.000001}
+	cm = check()
+	c.Assert(cm.changedFiles, HasLen, 0)
+	c.Assert(cm.run.ContentSkipped, Equals, int64(4))
+	c.Assert(cm.run.Cursor, Equals, "")
+}
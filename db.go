@@ -2,6 +2,7 @@ package fcheck
 
 import (
 	"bufio"
+	"bytes"
 	"encoding"
 	"encoding/binary"
 	"errors"
@@ -9,16 +10,120 @@ import (
 	"io"
 	"log"
 	"os"
-	"strings"
 	"sync"
+	"time"
 )
 
+//DBError is returned when reading from or writing to the DB file fails
+type DBError struct {
+	Err error
+}
+
+func (e *DBError) Error() string {
+	return "db error: " + e.Err.Error()
+}
+
+//dbError wraps err in DBError unless it is nil or already one
+func dbError(err error) error {
+	if _, ok := err.(*DBError); ok || err == nil {
+		return err
+	}
+	return &DBError{err}
+}
+
+//dbMagic starts the header record, no file path can contain the NUL byte so it can not be mistaken for a file
+//DBs written by older versions have no header and start with the first file record
+const dbMagic = "\x00fcheck db"
+
+//errNoHeader is returned when decoding a DBHeader from a file record
+var errNoHeader = errors.New("no db header")
+
+//DBHeader is the first record of a DB, it describes how the DB was generated
+type DBHeader struct {
+	Roots   []string  // paths that were walked, DB entries lie below them
+	Created time.Time // when the DB was generated
+	Label   string    // given by the operator to tell generations apart, optional
+}
+
+//MarshalBinary implements encoding/binary Marshaller
+func (h *DBHeader) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	bw := &binaryWriter{}
+	bw.Write(&buf, uint16(len(dbMagic)))
+	buf.WriteString(dbMagic)
+	sertime, err := h.Created.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	bw.Write(&buf, uint16(len(sertime)))
+	buf.Write(sertime)
+	bw.Write(&buf, uint16(len(h.Roots)))
+	for _, v := range h.Roots {
+		bw.Write(&buf, uint16(len(v)))
+		buf.WriteString(v)
+	}
+	bw.Write(&buf, uint16(len(h.Label)))
+	buf.WriteString(h.Label)
+	return buf.Bytes(), bw.Err()
+}
+
+//UnmarshalBinary implements encoding/binary Unmarshaller, it returns errNoHeader if data is not a header record
+func (h *DBHeader) UnmarshalBinary(data []byte) error {
+	var blen, count uint16
+	var pos, nextpos int
+	br := &binaryReader{}
+	byr := bytes.NewReader(data)
+	br.Read(byr, &blen)
+	pos = pos + 2 // two bytes read for unit16
+	nextpos = pos + int(blen)
+	if string(br.Slice(data, pos, nextpos)) != dbMagic {
+		return errNoHeader
+	}
+	pos = nextpos
+	byr.Seek(int64(pos), 0)
+	//created
+	br.Read(byr, &blen)
+	pos = pos + 2
+	nextpos = pos + int(blen)
+	if err := (&h.Created).UnmarshalBinary(br.Slice(data, pos, nextpos)); err != nil && br.Err() == nil {
+		return err
+	}
+	pos = nextpos
+	byr.Seek(int64(pos), 0)
+	//roots
+	br.Read(byr, &count)
+	pos = pos + 2
+	h.Roots = make([]string, 0, count)
+	for i := 0; i < int(count) && br.Err() == nil; i++ {
+		br.Read(byr, &blen)
+		pos = pos + 2
+		nextpos = pos + int(blen)
+		h.Roots = append(h.Roots, string(br.Slice(data, pos, nextpos)))
+		pos = nextpos
+		byr.Seek(int64(pos), 0)
+	}
+	if br.Err() != nil || pos == len(data) {
+		//header without label
+		return br.Err()
+	}
+	//label
+	br.Read(byr, &blen)
+	pos = pos + 2
+	nextpos = pos + int(blen)
+	h.Label = string(br.Slice(data, pos, nextpos))
+	return br.Err()
+}
+
 //DBWriter represents the underlying datastore that stores the actual filesystem entries
+//entries are written to a temporary file that replaces the DB file on Stop, so an interrupted run leaves the old DB intact
 type DBWriter struct {
 	dbfile   string
-	wChan    chan *FileCheckInfo
+	wChan    chan encoding.BinaryMarshaler
 	quitChan chan bool
+	syncChan chan chan int64
 	fout     io.WriteCloser
+	written  int64 // bytes written to fout
+	err      error // first write error, returned by Stop
 }
 
 //NewDBWriter returns new instance of DBWriter
@@ -28,28 +133,101 @@ func NewDBWriter(dbfname string) *DBWriter {
 
 //Start performs any needed initialization
 func (r *DBWriter) Start() error {
-	f, err := os.Create(r.dbfile)
+	f, err := os.Create(r.tmpName())
 	if err != nil {
-		return err
+		return dbError(err)
+	}
+	r.run(f, 0)
+	return nil
+}
+
+//StartAt continues writing the temporary DB left by Suspend (or a crash) after its first size bytes
+//whatever was written after them is discarded
+func (r *DBWriter) StartAt(size int64) error {
+	f, err := os.OpenFile(r.tmpName(), os.O_RDWR, 0)
+	if err != nil {
+		return dbError(err)
+	}
+	fi, err := f.Stat()
+	if err == nil && fi.Size() < size {
+		err = fmt.Errorf("%s is shorter than the %d bytes written before", r.tmpName(), size)
+	}
+	if err == nil {
+		err = f.Truncate(size)
+	}
+	if err == nil {
+		_, err = f.Seek(size, io.SeekStart)
 	}
+	if err != nil {
+		f.Close()
+		return dbError(err)
+	}
+	r.run(f, size)
+	return nil
+}
+
+//run starts the writer routine writing to f, which already holds written bytes
+func (r *DBWriter) run(f io.WriteCloser, written int64) {
 	//make channel
-	r.wChan = make(chan *FileCheckInfo)
+	r.wChan = make(chan encoding.BinaryMarshaler)
 	r.quitChan = make(chan bool)
+	r.syncChan = make(chan chan int64)
 	r.fout = f
+	r.written = written
 	go r.writer()
-	return nil
 }
 
-//Stop performs any needed cleanup
+//Stop performs any needed cleanup and replaces the DB file with the one just written
 func (r *DBWriter) Stop() error {
+	r.close()
+	if r.err == nil {
+		r.err = os.Rename(r.tmpName(), r.dbfile)
+	}
+	return dbError(r.err)
+}
+
+//Abort stops writing and removes what was written so far, the DB file is left as it was
+func (r *DBWriter) Abort() error {
+	r.close()
+	if err := os.Remove(r.tmpName()); err != nil && !os.IsNotExist(err) {
+		return dbError(err)
+	}
+	return nil
+}
+
+//Suspend stops writing but keeps what was written so far for StartAt, the DB file is left as it was
+func (r *DBWriter) Suspend() error {
+	r.close()
+	return dbError(r.err)
+}
+
+//Sync waits for the entries already put to be written and returns the number of bytes written so far
+func (r *DBWriter) Sync() (int64, error) {
+	ch := make(chan int64)
+	r.syncChan <- ch
+	return <-ch, dbError(r.err)
+}
+
+func (r *DBWriter) close() {
 	numWorkers := 1
 	for i := 0; i < numWorkers; i++ {
 		r.quitChan <- true
 	}
 	if r.fout != nil {
-		return r.fout.Close()
+		if err := r.fout.Close(); err != nil && r.err == nil {
+			r.err = err
+		}
 	}
-	return nil
+}
+
+//tmpName is the file the DB is written to until it is complete
+func (r *DBWriter) tmpName() string {
+	return tempDBName(r.dbfile)
+}
+
+//tempDBName returns the name of the temporary file a DBWriter writes the DB dbfname to
+func tempDBName(dbfname string) string {
+	return dbfname + ".new"
 }
 
 //Put puts an entry in the datastore
@@ -58,28 +236,42 @@ func (r *DBWriter) Put(fc *FileCheckInfo) error {
 	return nil
 }
 
+//PutHeader writes the DB header, it has to be called before any Put
+func (r *DBWriter) PutHeader(h *DBHeader) error {
+	r.wChan <- h
+	return nil
+}
+
 func (r *DBWriter) writer() {
 	for {
 		select {
-		case fc := <-r.wChan:
-			if err := encode(r.fout, fc); err != nil {
+		case m := <-r.wChan:
+			n, err := encode(r.fout, m)
+			r.written += n
+			if err != nil {
 				log.Print("trouble writing to db file: ", err.Error())
+				if r.err == nil {
+					r.err = err
+				}
 			}
+		case ch := <-r.syncChan:
+			ch <- r.written
 		case <-r.quitChan:
 			return
 		}
 	}
 }
 
-func encode(out io.Writer, m encoding.BinaryMarshaler) error {
+//encode writes m with its length prefix to out and returns the number of bytes written
+func encode(out io.Writer, m encoding.BinaryMarshaler) (int64, error) {
 	data, err := m.MarshalBinary()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	blen := uint16(len(data))
 	err = binary.Write(out, binary.LittleEndian, blen)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	written := 0
 	for err == nil && written < len(data) {
@@ -90,7 +282,7 @@ func encode(out io.Writer, m encoding.BinaryMarshaler) error {
 		num, err = out.Write(data[written:])
 		written += num
 	}
-	return err
+	return int64(2 + written), err
 }
 
 func decode(in io.Reader, m encoding.BinaryUnmarshaler) error {
@@ -118,10 +310,12 @@ func decode(in io.Reader, m encoding.BinaryUnmarshaler) error {
 
 //DBReader is a simple implementation of FileInfoReader
 type DBReader struct {
-	dbfile string
-	index  *PathIndex
-	db     *os.File
-	l      sync.Mutex
+	dbfile    string
+	index     *PathIndex
+	db        *os.File
+	header    *DBHeader
+	dataStart int64 // offset of the first file record
+	l         sync.Mutex
 }
 
 //ErrNotFound signifies that such FileCheckInfo entry could not be find
@@ -136,23 +330,55 @@ func NewDBReader(dbfname string) *DBReader {
 func (r *DBReader) Start() error {
 	rs, err := os.Open(r.dbfile)
 	if err != nil {
-		return err
+		return dbError(err)
 	}
 	r.db = rs
+	//read the header if there is one
+	in := NewPositionReader(rs)
+	var h DBHeader
+	switch err = decode(in, &h); err {
+	case nil:
+		r.header = &h
+		r.dataStart = in.Position()
+	case errNoHeader, io.EOF:
+		r.header = nil
+		r.dataStart = 0
+	default:
+		return dbError(err)
+	}
 	return nil
 }
 
+//Header returns the DB header or nil for DBs written by older versions
+func (r *DBReader) Header() *DBHeader {
+	return r.header
+}
+
+//openRecords opens the DB file positioned at the first file record
+func (r *DBReader) openRecords() (*os.File, error) {
+	fi, err := os.Open(r.dbfile)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = fi.Seek(r.dataStart, os.SEEK_SET); err != nil {
+		fi.Close()
+		return nil, err
+	}
+	return fi, nil
+}
+
 //GenerateIndex will generate in memory index for faster record seeks from DB file
 func (r *DBReader) GenerateIndex() error {
 	log.Println("Generating Index")
 	idx := NewPathIndex()
-	fi, err := os.Open(r.dbfile)
+	fi, err := r.openRecords()
 	if err != nil {
-		return err
+		return dbError(err)
 	}
 	defer fi.Close()
 	bif := bufio.NewReader(fi)
 	in := NewPositionReader(bif)
+	in.pos = r.dataStart
 	var (
 		pos int64
 		fc  FileCheckInfo
@@ -162,7 +388,7 @@ func (r *DBReader) GenerateIndex() error {
 		if err = decode(in, &fc); err != nil {
 			if err != io.EOF {
 				log.Printf("trouble in decode: %s\n", err.Error())
-				return err
+				return dbError(err)
 			}
 			break
 		}
@@ -173,9 +399,17 @@ func (r *DBReader) GenerateIndex() error {
 	return nil
 }
 
+//Count returns the number of entries at or below path, GenerateIndex has to be called first
+func (r *DBReader) Count(path string) int64 {
+	if node, ok := r.index.GetNode(path); ok {
+		return node.size()
+	}
+	return 0
+}
+
 //Stop performs any needed cleanup
 func (r *DBReader) Stop() error {
-	return r.db.Close()
+	return dbError(r.db.Close())
 }
 
 //Get retrieves an entry from db
@@ -192,21 +426,39 @@ func (r *DBReader) Get(key string) (*FileCheckInfo, error) {
 	//seek to where our record is at
 	_, err := r.db.Seek(offset, os.SEEK_SET)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
 	//actual read
-	err = decode(r.db, &fc)
+	if err = decode(r.db, &fc); err != nil {
+		return nil, dbError(err)
+	}
 	if fc.Path != key {
-		log.Fatalf("Something went terribly wrong key(%s) does not equal path(%s) at %d", key, fc.Path, offset)
+		return nil, dbError(fmt.Errorf("Something went terribly wrong key(%s) does not equal path(%s) at %d", key, fc.Path, offset))
 	}
-	return &fc, err
+	return &fc, nil
 }
 
-//Map maps FileCheckInfo entries in db whose paths match path to DBMapFunc f
+//Map maps FileCheckInfo entries in db whose paths match path (path itself and anything below it) to DBMapFunc f
 func (r *DBReader) Map(path string, f DBMapFunc) error {
-	fi, err := os.Open(r.dbfile)
+	return r.mapAll(func(fc *FileCheckInfo) error {
+		if !HasPathPrefix(fc.Path, path) {
+			return nil
+		}
+		return f(fc)
+	})
+}
+
+//Paths calls f with the paths of the entries at or below path in walk order without reading their records,
+//if f returns filepath.SkipDir the entries below the path it was called with are skipped, GenerateIndex has to be called first
+func (r *DBReader) Paths(path string, f func(path string) error) error {
+	return r.index.Walk(path, f)
+}
+
+//mapAll maps all FileCheckInfo entries in db to f
+func (r *DBReader) mapAll(f DBMapFunc) error {
+	fi, err := r.openRecords()
 	if err != nil {
-		return err
+		return dbError(err)
 	}
 	defer fi.Close()
 	bif := bufio.NewReader(fi)
@@ -214,15 +466,14 @@ func (r *DBReader) Map(path string, f DBMapFunc) error {
 		var fc FileCheckInfo
 		if err = decode(bif, &fc); err != nil {
 			if err != io.EOF {
-				log.Printf("trouble calling decode for %s: %s\n", path, err.Error())
-				return err
+				log.Printf("trouble calling decode: %s\n", err.Error())
+				return dbError(err)
 			}
 			break
 		}
-		if !strings.HasPrefix(fc.Path, path) {
-			continue
+		if err = f(&fc); err != nil {
+			return err
 		}
-		f(&fc)
 	}
 	return nil
 }
@@ -0,0 +1,231 @@
+package fcheck
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//PathRewrite maps the paths below From to the same paths below To, e.g. /mnt/imageA/etc/passwd to /etc/passwd
+type PathRewrite struct {
+	From string
+	To   string
+}
+
+//ParsePathRewrite parses a rewrite given as FROM=TO
+func ParsePathRewrite(s string) (PathRewrite, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 || i == len(s)-1 {
+		return PathRewrite{}, fmt.Errorf("invalid rewrite %q, expected FROM=TO", s)
+	}
+	return PathRewrite{filepath.Clean(s[:i]), filepath.Clean(s[i+1:])}, nil
+}
+
+//Apply returns path rewritten, paths that are not below From are returned as they are
+func (rw PathRewrite) Apply(path string) string {
+	if !HasPathPrefix(path, rw.From) {
+		return path
+	}
+	return filepath.Join(rw.To, strings.TrimPrefix(filepath.Clean(path), rw.From))
+}
+
+//DBDiff compares two DBs the way Comparator compares a DB with the filesystem, without touching the filesystem
+//entries of the old DB are rewritten before being matched with those of the new one
+type DBDiff struct {
+	old         FileInfoReader
+	new         FileInfoReader
+	rewrites    []PathRewrite
+	policy      *Policy
+	minSeverity Severity
+	reporter    Reporter
+	console     io.Writer
+	run         RunInfo
+	findings    findingList
+}
+
+//NewDBDiff returns new DBDiff reporting what changed from the DB in oldfname to the one in newfname
+func NewDBDiff(oldfname, newfname string) *DBDiff {
+	return &DBDiff{
+		old:     NewDBReader(oldfname),
+		new:     NewDBReader(newfname),
+		console: os.Stdout,
+		run:     RunInfo{DB: newfname, Against: oldfname}}
+}
+
+//SetRewrites sets the rewrites applied to the paths of the old DB, the first one matching is applied
+func (d *DBDiff) SetRewrites(rw ...PathRewrite) {
+	d.rewrites = rw
+}
+
+//SetPolicy sets the Policy deciding which attributes are compared for each path, by default DefaultAttrs are
+//attributes missing from either DB are never compared
+func (d *DBDiff) SetPolicy(p *Policy) {
+	d.policy = p
+}
+
+//SetMinSeverity sets the Severity below which findings are left out of the report and the counts
+func (d *DBDiff) SetMinSeverity(s Severity) {
+	d.minSeverity = s
+}
+
+//SetReporter sets the Reporter used to render findings, by default a text report is printed to console
+func (d *DBDiff) SetReporter(rep Reporter) {
+	d.reporter = rep
+}
+
+//Start opens both DBs
+func (d *DBDiff) Start() error {
+	if d.reporter == nil {
+		d.reporter = &TextReporter{out: d.console}
+	}
+	if err := d.old.Start(); err != nil {
+		return err
+	}
+	if err := d.new.Start(); err != nil {
+		d.old.Stop()
+		return err
+	}
+	if err := d.new.GenerateIndex(); err != nil {
+		d.new.Stop()
+		d.old.Stop()
+		return err
+	}
+	return nil
+}
+
+//Header returns the header of the new DB
+func (d *DBDiff) Header() *DBHeader {
+	return d.new.Header()
+}
+
+//rewrite returns path of the old DB as it is in the new one
+func (d *DBDiff) rewrite(path string) string {
+	for _, rw := range d.rewrites {
+		if HasPathPrefix(path, rw.From) {
+			return rw.Apply(path)
+		}
+	}
+	return path
+}
+
+//oldRoots returns the prefixes to map the old DB with, its header roots or else the roots rewritten back
+func (d *DBDiff) oldRoots(roots PathPrefixes) PathPrefixes {
+	if h := d.old.Header(); h != nil && len(h.Roots) > 0 {
+		return NewPathPrefixes(h.Roots...).Outermost()
+	}
+	var back []string
+	for _, root := range roots {
+		back = append(back, root)
+		for _, rw := range d.rewrites {
+			back = append(back, PathRewrite{rw.To, rw.From}.Apply(root))
+		}
+	}
+	return NewPathPrefixes(back...).Outermost()
+}
+
+//StartWalking compares the entries of both DBs at or below roots (paths of the new DB), entries excluded are skipped
+func (d *DBDiff) StartWalking(ctx context.Context, roots []string, exclude *Matcher) error {
+	walk := NewPathPrefixes(roots...).Outermost()
+	if len(walk) == 0 {
+		return errNoRoots
+	}
+	d.run.Roots = walk
+	d.run.MinSeverity = d.minSeverity
+	d.run.Host, _ = os.Hostname()
+	d.run.Started = time.Now()
+	if err := d.reporter.Begin(&d.run); err != nil {
+		log.Printf("Trouble writing report: %s\n", err)
+	}
+	seen := make(StringSet)
+	for _, root := range d.oldRoots(walk) {
+		err := d.old.Map(root, func(old *FileCheckInfo) error {
+			path := d.rewrite(old.Path)
+			if err := ctx.Err(); err != nil {
+				d.interrupted(path)
+				return err
+			}
+			if !walk.Match(path) || seen.Has(path) || exclude.dbExcluded(path, old.Mode) {
+				return nil
+			}
+			seen.Add(path)
+			d.run.Checked++
+			rec := *old
+			rec.Path = path
+			cur, err := d.new.Get(path)
+			if err == ErrNotFound {
+				d.addFinding(&Finding{Kind: KindRemoved, Path: path, Old: &rec})
+				return nil
+			} else if err != nil {
+				return err
+			}
+			attrs := d.policy.Attrs(path, cur.Mode).anyDigest() & rec.Attrs & cur.Attrs
+			if changes := cur.Diff(&rec, attrs); len(changes) > 0 {
+				d.addFinding(&Finding{Kind: KindChanged, Path: path, Changes: changes, Old: &rec, New: cur})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, root := range walk {
+		err := d.new.Map(root, func(cur *FileCheckInfo) error {
+			if err := ctx.Err(); err != nil {
+				d.interrupted(cur.Path)
+				return err
+			}
+			if walk.Longest(cur.Path) != root || seen.Has(cur.Path) || exclude.dbExcluded(cur.Path, cur.Mode) {
+				return nil
+			}
+			d.run.Checked++
+			d.addFinding(&Finding{Kind: KindNew, Path: cur.Path, New: cur})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//interrupted marks the report incomplete, nothing after path was compared
+func (d *DBDiff) interrupted(path string) {
+	d.run.Incomplete = true
+	d.run.InterruptedAt = path
+}
+
+//addFinding assigns severity to the finding, counts it and passes it on to the reporter
+func (d *DBDiff) addFinding(f *Finding) {
+	f.Severity = d.policy.Severity(f)
+	if f.Severity < d.minSeverity {
+		d.run.Suppressed++
+		return
+	}
+	d.findings.add(f)
+	if err := d.reporter.Finding(f); err != nil {
+		log.Printf("Trouble writing report: %s\n", err)
+	}
+}
+
+//Counts returns the number of findings of each kind
+func (d *DBDiff) Counts() map[string]int {
+	return d.findings.counts()
+}
+
+//Stop closes both DBs and renders the report
+func (d *DBDiff) Stop() error {
+	d.run.Finished = time.Now()
+	err := d.reporter.End(&d.run)
+	if serr := d.old.Stop(); err == nil {
+		err = serr
+	}
+	if serr := d.new.Stop(); err == nil {
+		err = serr
+	}
+	return err
+}
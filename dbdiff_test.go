@@ -0,0 +1,75 @@
+package fcheck
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type DBDiffSuite struct {
+	scratch
+}
+
+var _ = Suite(&DBDiffSuite{})
+
+func (s *DBDiffSuite) SetUpTest(c *C) {
+	s.setUp(c)
+}
+
+func (s *DBDiffSuite) TestDBDiff(c *C) {
+	rw, err := ParsePathRewrite("/mnt/imageA/=/")
+	c.Assert(err, IsNil)
+	c.Assert(rw.Apply("/mnt/imageA/etc/passwd"), Equals, "/etc/passwd")
+	c.Assert(rw.Apply("/mnt/imageAB/etc/passwd"), Equals, "/mnt/imageAB/etc/passwd")
+	_, err = ParsePathRewrite("=/")
+	c.Assert(err, NotNil)
+
+	image, live := filepath.Join(s.root, "image"), filepath.Join(s.root, "live")
+	for _, dir := range []string{image, live} {
+		s.write(c, filepath.Join(filepath.Base(dir), "etc/conf"), "setting=1")
+		s.write(c, filepath.Join(filepath.Base(dir), "bin/tool"), "tool binary")
+	}
+	//as if live was restored from the image
+	stamp := time.Now().Add(-time.Hour)
+	for _, dir := range []string{image, live} {
+		c.Assert(os.Chtimes(filepath.Join(dir, "bin/tool"), stamp, stamp), IsNil)
+	}
+	s.write(c, "image/etc/gone", "removed later")
+	s.write(c, "live/etc/conf", "setting=2")
+	s.write(c, "live/etc/extra", "added later")
+	oldDB := filepath.Join(c.MkDir(), "image.db")
+	for db, root := range map[string]string{oldDB: image, s.dbName: live} {
+		g := NewGenerator(db, 2, false)
+		c.Assert(g.Start(), IsNil)
+		c.Assert(g.StartWalking(context.Background(), []string{root}, NewMatcher()), IsNil)
+		c.Assert(g.Stop(), IsNil)
+	}
+
+	var buf bytes.Buffer
+	d := NewDBDiff(oldDB, s.dbName)
+	d.console = &buf
+	d.SetRewrites(PathRewrite{image, live})
+	c.Assert(d.Start(), IsNil)
+	c.Assert(d.StartWalking(context.Background(), []string{live}, NewMatcher()), IsNil)
+	c.Assert(d.Stop(), IsNil)
+	paths := func(kind string) []string {
+		var ps []string
+		for _, f := range d.findings.byKind[kind] {
+			//directories change whenever their entries do
+			if rec := f.Old; rec != nil && !rec.Mode.IsDir() || rec == nil && !f.New.Mode.IsDir() {
+				ps = append(ps, f.Path)
+			}
+		}
+		return ps
+	}
+	c.Assert(paths(KindRemoved), DeepEquals, []string{filepath.Join(live, "etc/gone")})
+	c.Assert(paths(KindNew), DeepEquals, []string{filepath.Join(live, "etc/extra")})
+	c.Assert(paths(KindChanged), DeepEquals, []string{filepath.Join(live, "etc/conf")})
+	c.Assert(d.run.Against, Equals, oldDB)
+	c.Assert(strings.Contains(buf.String(), filepath.Join(live, "etc/conf")), Equals, true)
+}
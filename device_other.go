@@ -0,0 +1,11 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package fcheck
+
+import "os"
+
+//deviceOf reports the device as unknown where there is no stat structure, -xdev excludes nothing there
+func deviceOf(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,199 @@
+package fcheck
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//mergedDirs are the directories of the root that merged /usr systems make links into /usr
+var mergedDirs = []string{"bin", "sbin", "lib", "lib32", "lib64", "libx32"}
+
+//Dpkg is an Annotator telling which Debian package owns the file of a finding and whether its content
+//is the one the package shipped, from dpkg's local metadata (the info/*.list, *.md5sums and *.conffiles files
+//and the conffile checksums in status), so that changes explained by package updates can be told apart
+type Dpkg struct {
+	owners    map[string]string // path -> package(s) owning it
+	sums      map[string][]byte // path -> md5 shipped, nil for empty files
+	conffiles StringSet
+	aliases   []PathRewrite // real paths of merged /usr systems to the paths packages list
+}
+
+//LoadDpkg reads the metadata in dpkg's admin directory dir (usually /var/lib/dpkg)
+//the merged /usr links are looked up in the root dir is the var/lib/dpkg of, the system root for other directories
+func LoadDpkg(dir string) (*Dpkg, error) {
+	d := &Dpkg{owners: make(map[string]string), sums: make(map[string][]byte), conffiles: make(StringSet)}
+	lists, err := filepath.Glob(filepath.Join(dir, "info", "*.list"))
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range lists {
+		if err := d.readList(list); err != nil {
+			return nil, err
+		}
+	}
+	sums, _ := filepath.Glob(filepath.Join(dir, "info", "*.md5sums"))
+	for _, fname := range sums {
+		im := NewImporter("")
+		if _, err := im.ReadFile(ImportSums, fname); err != nil {
+			return nil, err
+		}
+		for path, fc := range im.records {
+			d.sums[path] = fc.Digest
+		}
+	}
+	conffiles, _ := filepath.Glob(filepath.Join(dir, "info", "*.conffiles"))
+	for _, fname := range conffiles {
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			//remove-on-upgrade conffiles are prefixed with their flags
+			if fields := strings.Fields(line); len(fields) > 0 {
+				d.conffiles.Add(filepath.Clean(fields[len(fields)-1]))
+			}
+		}
+	}
+	if err := d.readStatus(filepath.Join(dir, "status")); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, dir := range mergedUsrDirs(dpkgRoot(dir)) {
+		d.aliases = append(d.aliases, PathRewrite{"/usr/" + dir, "/" + dir})
+	}
+	return d, nil
+}
+
+//mergedUsrDirs returns those of mergedDirs that root links into its /usr, as merged /usr systems do
+func mergedUsrDirs(root string) []string {
+	var dirs []string
+	for _, dir := range mergedDirs {
+		//relative or absolute, the link is resolved within root
+		if target, err := os.Readlink(filepath.Join(root, dir)); err == nil && filepath.Join("/", target) == "/usr/"+dir {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+//dpkgRoot returns the root of the system whose admin directory is dir, / unless dir is the var/lib/dpkg of another one
+func dpkgRoot(dir string) string {
+	const admin = "/var/lib/dpkg"
+	if dir, err := filepath.Abs(dir); err == nil && strings.HasSuffix(dir, admin) && dir != admin {
+		return strings.TrimSuffix(dir, admin)
+	}
+	return "/"
+}
+
+//readList reads the paths a package owns from its .list file, the package is named after the file
+func (d *Dpkg) readList(fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	pkg := strings.TrimSuffix(filepath.Base(fname), ".list")
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		path := filepath.Clean(sc.Text())
+		//every package lists the root as /.
+		if path == "/" || path == "." {
+			continue
+		}
+		switch owners := d.owners[path]; {
+		case owners == "":
+			d.owners[path] = pkg
+		case !strings.Contains(", "+owners+", ", ", "+pkg+", "):
+			//directories are shared
+			d.owners[path] = owners + ", " + pkg
+		}
+	}
+	return sc.Err()
+}
+
+//readStatus reads the checksums of conffiles from dpkg's status file, they are not in the .md5sums files
+func (d *Dpkg) readStatus(fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	conffiles := false
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.HasPrefix(line, " ") {
+			conffiles = strings.HasPrefix(line, "Conffiles:")
+			continue
+		}
+		//  /etc/foo.conf 0123456789abcdef0123456789abcdef [obsolete]
+		if fields := strings.Fields(line); conffiles && len(fields) >= 2 {
+			path := filepath.Clean(fields[0])
+			fc := &FileCheckInfo{Path: path}
+			if err := setDigest(fc, fields[1]); err == nil && fc.Attrs == AttrMD5 {
+				d.sums[path] = fc.Digest
+				d.conffiles.Add(path)
+			}
+		}
+	}
+	return sc.Err()
+}
+
+//Owner returns the package(s) owning path and the path they list it as, "" if no package does
+func (d *Dpkg) Owner(path string) (string, string) {
+	path = filepath.Clean(path)
+	if pkg, ok := d.owners[path]; ok {
+		return pkg, path
+	}
+	for _, rw := range d.aliases {
+		if listed := rw.Apply(path); listed != path {
+			if pkg, ok := d.owners[listed]; ok {
+				return pkg, listed
+			}
+		}
+	}
+	return "", path
+}
+
+//Annotate implements Annotator, whether the content is the one shipped is left as AnnotateContent found
+func (d *Dpkg) Annotate(f *Finding) {
+	pkg, listed := d.Owner(f.Path)
+	if pkg == "" {
+		f.Shipped = ShippedUnowned
+		return
+	}
+	f.Package = pkg
+	f.Conffile = d.conffiles.Has(listed)
+	if f.New == nil {
+		//removed
+		return
+	}
+	if f.Shipped == "" {
+		f.Shipped = ShippedUnknown
+	}
+}
+
+//AnnotateContent implements ContentAnnotator, comparing the content of the file with the md5 its package shipped
+func (d *Dpkg) AnnotateContent(f *Finding) {
+	if f.New == nil || !f.New.Mode.IsRegular() {
+		return
+	}
+	_, listed := d.Owner(f.Path)
+	sum, ok := d.sums[listed]
+	if !ok {
+		return
+	}
+	fc := &FileCheckInfo{Path: f.Path, Mode: f.New.Mode, Size: f.New.Size, Attrs: AttrMD5}
+	if err := fc.CalcDigest(); err != nil {
+		return
+	}
+	if bytes.Equal(fc.Digest, sum) {
+		f.Shipped = ShippedMatch
+	} else {
+		f.Shipped = ShippedDiffer
+	}
+}
@@ -0,0 +1,129 @@
+package fcheck
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type DpkgSuite struct {
+	scratch
+	admin string
+}
+
+var _ = Suite(&DpkgSuite{})
+
+//SetUpTest makes a package tool owning bin/tool, etc/tool.conf (a conffile) and bin/gone
+func (s *DpkgSuite) SetUpTest(c *C) {
+	s.setUp(c)
+	s.admin = c.MkDir()
+	info := filepath.Join(s.admin, "info")
+	c.Assert(os.MkdirAll(info, 0755), IsNil)
+	s.write(c, "bin/tool", "tool binary")
+	s.write(c, "etc/tool.conf", "edited")
+	list := strings.Join([]string{"/.", s.root, s.root + "/bin", s.root + "/bin/tool", s.root + "/bin/gone", s.root + "/etc/tool.conf"}, "\n")
+	c.Assert(ioutil.WriteFile(filepath.Join(info, "tool:amd64.list"), []byte(list+"\n"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(info, "other.list"), []byte(s.root+"/bin\n"), 0644), IsNil)
+	sums := fmt.Sprintf("%x  %s/bin/tool\n", md5.Sum([]byte("tool binary")), strings.TrimPrefix(s.root, "/"))
+	c.Assert(ioutil.WriteFile(filepath.Join(info, "tool:amd64.md5sums"), []byte(sums), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(info, "tool:amd64.conffiles"), []byte(s.root+"/etc/tool.conf\n"), 0644), IsNil)
+	status := fmt.Sprintf("Package: tool\nConffiles:\n %s/etc/tool.conf %x\nDescription: a tool\n", s.root, md5.Sum([]byte("shipped")))
+	c.Assert(ioutil.WriteFile(filepath.Join(s.admin, "status"), []byte(status), 0644), IsNil)
+}
+
+func (s *DpkgSuite) finding(c *C, kind, name string) *Finding {
+	path := filepath.Join(s.root, name)
+	f := &Finding{Kind: kind, Path: path}
+	if info, err := os.Lstat(path); err == nil {
+		f.New = NewFileCheckInfo(path, info)
+	}
+	return f
+}
+
+//annotate annotates f as Comparator does, the content first
+func annotate(d *Dpkg, f *Finding) {
+	d.AnnotateContent(f)
+	d.Annotate(f)
+}
+
+func (s *DpkgSuite) TestAnnotate(c *C) {
+	d, err := LoadDpkg(s.admin)
+	c.Assert(err, IsNil)
+
+	f := s.finding(c, KindChanged, "bin/tool")
+	annotate(d, f)
+	c.Assert([]interface{}{f.Package, f.Conffile, f.Shipped}, DeepEquals, []interface{}{"tool:amd64", false, ShippedMatch})
+	c.Assert(f.note(), Equals, " [tool:amd64, as shipped]")
+
+	s.write(c, "bin/tool", "patched binary")
+	f = s.finding(c, KindChanged, "bin/tool")
+	annotate(d, f)
+	c.Assert(f.Shipped, Equals, ShippedDiffer)
+
+	f = s.finding(c, KindChanged, "etc/tool.conf")
+	annotate(d, f)
+	c.Assert([]interface{}{f.Package, f.Conffile, f.Shipped}, DeepEquals, []interface{}{"tool:amd64", true, ShippedDiffer})
+	c.Assert(f.note(), Equals, " [tool:amd64 conffile, not as shipped]")
+
+	f = s.finding(c, KindChanged, "bin")
+	annotate(d, f)
+	c.Assert([]interface{}{f.Package, f.Shipped}, DeepEquals, []interface{}{"other, tool:amd64", ShippedUnknown})
+
+	f = s.finding(c, KindRemoved, "bin/gone")
+	annotate(d, f)
+	c.Assert([]interface{}{f.Package, f.Shipped}, DeepEquals, []interface{}{"tool:amd64", ""})
+
+	s.write(c, "bin/dropped", "not from a package")
+	f = s.finding(c, KindNew, "bin/dropped")
+	annotate(d, f)
+	c.Assert([]interface{}{f.Package, f.Shipped}, DeepEquals, []interface{}{"", ShippedUnowned})
+	c.Assert(f.note(), Equals, " [no package]")
+}
+
+func (s *DpkgSuite) TestSeverity(c *C) {
+	d, err := LoadDpkg(s.admin)
+	c.Assert(err, IsNil)
+	p, err := ParsePolicy(strings.NewReader("severity=info shipped=match /\nseverity=high kind=new shipped=unowned /\n"))
+	c.Assert(err, IsNil)
+	s.write(c, "bin/dropped", "not from a package")
+	for name, sev := range map[string]Severity{"bin/tool": SeverityInfo, "etc/tool.conf": DefaultSeverity, "bin/dropped": SeverityHigh} {
+		f := s.finding(c, KindNew, name)
+		annotate(d, f)
+		c.Assert(p.Severity(f), Equals, sev, Commentf("%s", name))
+	}
+}
+
+func (s *DpkgSuite) TestMergedUsr(c *C) {
+	//an image with a merged /usr, the links are looked up in it rather than in the system root
+	image := c.MkDir()
+	admin := filepath.Join(image, "var/lib/dpkg")
+	c.Assert(os.MkdirAll(filepath.Join(admin, "info"), 0755), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(image, "usr/sbin"), 0755), IsNil)
+	c.Assert(os.Symlink("usr/sbin", filepath.Join(image, "sbin")), IsNil)
+	c.Assert(os.Symlink("/usr/lib", filepath.Join(image, "lib")), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(admin, "info", "tool.list"), []byte("/sbin/tool\n/lib/libtool.so\n"), 0644), IsNil)
+	c.Assert(dpkgRoot(admin), Equals, image)
+	c.Assert(dpkgRoot(s.admin), Equals, "/")
+	d, err := LoadDpkg(admin)
+	c.Assert(err, IsNil)
+	c.Assert(d.aliases, DeepEquals, []PathRewrite{{"/usr/sbin", "/sbin"}, {"/usr/lib", "/lib"}})
+	pkg, listed := d.Owner("/usr/sbin/tool")
+	c.Assert([]string{pkg, listed}, DeepEquals, []string{"tool", "/sbin/tool"})
+}
+
+func (s *DpkgSuite) TestCheck(c *C) {
+	d, err := LoadDpkg(s.admin)
+	c.Assert(err, IsNil)
+	s.generate(c)
+	s.write(c, "bin/tool", "patched binary")
+	//the content is compared in the compare workers
+	_, buf := s.compare(c, func(cm *Comparator) {
+		cm.AddAnnotator(d)
+	})
+	c.Assert(strings.Contains(buf.String(), filepath.Join(s.root, "bin/tool")+" [tool:amd64, not as shipped]"), Equals, true, Commentf("%s", buf.String()))
+}
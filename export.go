@@ -0,0 +1,303 @@
+package fcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Export formats understood by NewExporter
+const (
+	ExportSHA512Sum = "sha512sum" // sha512sum -c input, regular files with a SHA512 digest only
+	ExportMtree     = "mtree"     // BSD mtree specification
+	ExportCSV       = "csv"       // one row per entry with a header row
+	ExportJSONL     = "jsonl"     // one JSON object per entry and line
+)
+
+//csvHeader names the columns of the CSV export
+var csvHeader = []string{"path", "type", "mode", "size", "mtime", "ctime", "uid", "gid", "inode", "nlink",
+	"sha512", "attrs", "prefix_len", "prefix_sha512", "sha256", "md5"}
+
+//Exporter is a DB only walker like Printer writing the entries in a format other tools read (flag export)
+type Exporter struct {
+	FileInfoReader
+	format  string
+	console io.Writer
+}
+
+//NewExporter returns new Exporter writing the entries of the DB in dbfname in format (sha512sum, mtree, csv or jsonl)
+func NewExporter(dbfname, format string) (*Exporter, error) {
+	switch format {
+	case ExportSHA512Sum, ExportMtree, ExportCSV, ExportJSONL:
+		return &Exporter{NewDBReader(dbfname), format, os.Stdout}, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+//StartWalking exports the entries at or below roots, it respects excludes the way Printer does
+func (r *Exporter) StartWalking(ctx context.Context, roots []string, exclude *Matcher) error {
+	var write func(fc *FileCheckInfo) error
+	var cw *csv.Writer
+	switch r.format {
+	case ExportSHA512Sum:
+		write = r.writeSHA512Sum
+	case ExportMtree:
+		if _, err := fmt.Fprintf(r.console, "#mtree\n"); err != nil {
+			return err
+		}
+		write = r.writeMtree
+	case ExportCSV:
+		cw = csv.NewWriter(r.console)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		write = func(fc *FileCheckInfo) error { return cw.Write(csvRecord(fc)) }
+	case ExportJSONL:
+		enc := json.NewEncoder(r.console)
+		write = func(fc *FileCheckInfo) error { return enc.Encode(jsonRecord(fc)) }
+	}
+	walk := NewPathPrefixes(roots...).Outermost()
+	for _, root := range walk {
+		err := r.Map(root, func(fc *FileCheckInfo) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if walk.Longest(fc.Path) != root || exclude.dbExcluded(fc.Path, fc.Mode) {
+				//path is excluded
+				return nil
+			}
+			return write(fc)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if cw != nil {
+		cw.Flush()
+		return cw.Error()
+	}
+	return nil
+}
+
+//writeSHA512Sum writes fc as sha512sum does, names with a backslash or newline are escaped and the line starts with \
+func (r *Exporter) writeSHA512Sum(fc *FileCheckInfo) error {
+	if !fc.Mode.IsRegular() || len(fc.Digest) == 0 || fc.digestAttr() != AttrSHA512 {
+		return nil
+	}
+	prefix, name := "", fc.Path
+	if strings.ContainsAny(name, "\\\n") {
+		prefix = "\\"
+		name = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(name)
+	}
+	_, err := fmt.Fprintf(r.console, "%s%x  %s\n", prefix, fc.Digest, name)
+	return err
+}
+
+//recorded tells whether fc records any of attrs, the other attributes of an entry are zero or were never checked
+//(imported checksums carry just their digest)
+func recorded(fc *FileCheckInfo, attrs Attr) bool {
+	return fc.Attrs&attrs != 0
+}
+
+//writeMtree writes fc as a full path mtree entry (relative to /, as mtree -c does) with the keywords mtree knows of
+//for the attributes fc records, the inode change time has no keyword and is left out
+func (r *Exporter) writeMtree(fc *FileCheckInfo) error {
+	var b strings.Builder
+	b.WriteString(mtreeEscape("." + fc.Path))
+	fmt.Fprintf(&b, " type=%s", mtreeType(fc.Mode))
+	if recorded(fc, AttrPerm) {
+		fmt.Fprintf(&b, " mode=%04o", unixPerm(fc.Mode))
+	}
+	if recorded(fc, AttrUser) {
+		fmt.Fprintf(&b, " uid=%d", fc.Uid)
+	}
+	if recorded(fc, AttrGroup) {
+		fmt.Fprintf(&b, " gid=%d", fc.Gid)
+	}
+	if recorded(fc, AttrLinks) {
+		fmt.Fprintf(&b, " nlink=%d", fc.Nlink)
+	}
+	if recorded(fc, AttrInode) {
+		fmt.Fprintf(&b, " inode=%d", fc.Inode)
+	}
+	if recorded(fc, AttrMtime) {
+		fmt.Fprintf(&b, " time=%d.%09d", fc.ModTime.Unix(), fc.ModTime.Nanosecond())
+	}
+	if fc.Mode.IsRegular() {
+		if recorded(fc, AttrSize|AttrGrowing) {
+			fmt.Fprintf(&b, " size=%d", fc.Size)
+		}
+		if len(fc.Digest) > 0 {
+			fmt.Fprintf(&b, " %sdigest=%x", fc.digestAttr(), fc.Digest)
+		}
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(r.console, b.String())
+	return err
+}
+
+//mtreeEscape escapes whitespace, non printable characters, backslashes, comments and globs as \ooo
+func mtreeEscape(path string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("\\#*?[", c) >= 0 {
+			fmt.Fprintf(&b, "\\%03o", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+//mtreeType returns the mtree type keyword value for mode
+func mtreeType(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "dir"
+	case mode&os.ModeSymlink != 0:
+		return "link"
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "char"
+	case mode&os.ModeDevice != 0:
+		return "block"
+	}
+	return "file"
+}
+
+//unixPerm returns the permission bits of mode the way chmod takes them, including setuid, setgid and sticky
+func unixPerm(mode os.FileMode) uint32 {
+	perm := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		perm |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		perm |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		perm |= 01000
+	}
+	return perm
+}
+
+//csvRecord returns the row of fc, in the order of csvHeader, the columns of attributes fc does not record are empty
+func csvRecord(fc *FileCheckInfo) []string {
+	hex := func(b []byte) string { return fmt.Sprintf("%x", b) }
+	digests := make(map[Attr]string)
+	if len(fc.Digest) > 0 {
+		digests[fc.digestAttr()] = hex(fc.Digest)
+	}
+	var mode, size, mtime, ctime, uid, gid, inode, nlink, plen string
+	if recorded(fc, AttrPerm) {
+		mode = fmt.Sprintf("%04o", unixPerm(fc.Mode))
+	}
+	if recorded(fc, AttrSize|AttrGrowing) {
+		size = strconv.FormatInt(fc.Size, 10)
+	}
+	if recorded(fc, AttrMtime) {
+		mtime = fc.ModTime.Format(time.RFC3339Nano)
+	}
+	if recorded(fc, AttrCtime) && !fc.CTime.IsZero() {
+		//not recorded by older DBs
+		ctime = fc.CTime.Format(time.RFC3339Nano)
+	}
+	if recorded(fc, AttrUser) {
+		uid = strconv.FormatUint(uint64(fc.Uid), 10)
+	}
+	if recorded(fc, AttrGroup) {
+		gid = strconv.FormatUint(uint64(fc.Gid), 10)
+	}
+	if recorded(fc, AttrInode) {
+		inode = strconv.FormatUint(fc.Inode, 10)
+	}
+	if recorded(fc, AttrLinks) {
+		nlink = strconv.FormatUint(fc.Nlink, 10)
+	}
+	if recorded(fc, AttrGrowing) {
+		plen = strconv.FormatInt(fc.PrefixLen, 10)
+	}
+	return []string{
+		fc.Path,
+		mtreeType(fc.Mode),
+		mode,
+		size,
+		mtime,
+		ctime,
+		uid,
+		gid,
+		inode,
+		nlink,
+		digests[AttrSHA512],
+		fc.Attrs.String(),
+		plen,
+		hex(fc.PrefixDigest),
+		digests[AttrSHA256],
+		digests[AttrMD5],
+	}
+}
+
+//jsonExport is the JSON object of an exported entry, attributes the entry does not record are left out
+type jsonExport struct {
+	Path    string     `json:"path"`
+	Type    string     `json:"type"`
+	Mode    *string    `json:"mode,omitempty"`
+	Size    *int64     `json:"size,omitempty"`
+	ModTime *time.Time `json:"mtime,omitempty"`
+	CTime   *time.Time `json:"ctime,omitempty"`
+	Uid     *uint32    `json:"uid,omitempty"`
+	Gid     *uint32    `json:"gid,omitempty"`
+	Inode   *uint64    `json:"inode,omitempty"`
+	Nlink   *uint64    `json:"nlink,omitempty"`
+	Digest  string     `json:"sha512,omitempty"`
+	Attrs   string     `json:"attrs"`
+	PLen    *int64     `json:"prefix_len,omitempty"`
+	PDigest string     `json:"prefix_sha512,omitempty"`
+	SHA256  string     `json:"sha256,omitempty"`
+	MD5     string     `json:"md5,omitempty"`
+}
+
+//jsonRecord returns the JSON object of fc, with the same attributes as its csvRecord row
+func jsonRecord(fc *FileCheckInfo) *jsonExport {
+	row := csvRecord(fc)
+	rec := &jsonExport{Path: fc.Path, Type: row[1], Digest: row[10], Attrs: row[11], PDigest: row[13],
+		SHA256: row[14], MD5: row[15]}
+	if recorded(fc, AttrPerm) {
+		rec.Mode = &row[2]
+	}
+	if recorded(fc, AttrSize|AttrGrowing) {
+		rec.Size = &fc.Size
+	}
+	if recorded(fc, AttrMtime) {
+		rec.ModTime = &fc.ModTime
+	}
+	if recorded(fc, AttrCtime) && !fc.CTime.IsZero() {
+		rec.CTime = &fc.CTime
+	}
+	if recorded(fc, AttrUser) {
+		rec.Uid = &fc.Uid
+	}
+	if recorded(fc, AttrGroup) {
+		rec.Gid = &fc.Gid
+	}
+	if recorded(fc, AttrInode) {
+		rec.Inode = &fc.Inode
+	}
+	if recorded(fc, AttrLinks) {
+		rec.Nlink = &fc.Nlink
+	}
+	if recorded(fc, AttrGrowing) {
+		rec.PLen = &fc.PrefixLen
+	}
+	return rec
+}
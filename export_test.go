@@ -0,0 +1,118 @@
+package fcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type ExportSuite struct {
+	scratch
+}
+
+var _ = Suite(&ExportSuite{})
+
+func (s *ExportSuite) SetUpTest(c *C) {
+	s.setUp(c)
+	s.writeMode(c, "bin/tool", "tool binary", 0755)
+	s.write(c, "odd name\n", "odd")
+	s.generate(c)
+}
+
+func (s *ExportSuite) export(c *C, format string) string {
+	var buf bytes.Buffer
+	e, err := NewExporter(s.dbName, format)
+	c.Assert(err, IsNil)
+	e.console = &buf
+	c.Assert(e.Start(), IsNil)
+	c.Assert(e.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+	c.Assert(e.Stop(), IsNil)
+	return buf.String()
+}
+
+func (s *ExportSuite) TestUnknownFormat(c *C) {
+	_, err := NewExporter(s.dbName, "xml")
+	c.Assert(err, NotNil)
+}
+
+func (s *ExportSuite) TestSHA512Sum(c *C) {
+	lines := strings.Split(strings.TrimSuffix(s.export(c, ExportSHA512Sum), "\n"), "\n")
+	sort.Strings(lines)
+	c.Assert(lines, HasLen, 2)
+	c.Assert(lines[1], Matches, `[0-9a-f]{128}  `+s.root+`/bin/tool`)
+	c.Assert(lines[0], Matches, `\\[0-9a-f]{128}  `+s.root+`/odd name\\n`)
+}
+
+func (s *ExportSuite) TestMtree(c *C) {
+	lines := strings.Split(strings.TrimSuffix(s.export(c, ExportMtree), "\n"), "\n")
+	c.Assert(lines, HasLen, 5)
+	c.Assert(lines[0], Equals, "#mtree")
+	entries := make(map[string]string)
+	for _, l := range lines[1:] {
+		i := strings.IndexByte(l, ' ')
+		entries[l[:i]] = l[i+1:]
+	}
+	//only the attributes of DefaultAttrs are recorded
+	c.Assert(entries["."+s.root+"/bin"], Matches, `type=dir mode=0755 time=\d+\.\d{9}`)
+	c.Assert(entries["."+s.root+"/bin/tool"], Matches, `type=file mode=0755 time=\d+\.\d{9} size=11 sha512digest=[0-9a-f]{128}`)
+	c.Assert(entries["."+s.root+`/odd\040name\012`], Matches, `type=file mode=0644 .*`)
+}
+
+func (s *ExportSuite) TestCSV(c *C) {
+	rows, err := csv.NewReader(strings.NewReader(s.export(c, ExportCSV))).ReadAll()
+	c.Assert(err, IsNil)
+	c.Assert(rows, HasLen, 5)
+	c.Assert(rows[0], DeepEquals, csvHeader)
+	byPath := make(map[string][]string)
+	for _, row := range rows[1:] {
+		byPath[row[0]] = row
+	}
+	tool := byPath[filepath.Join(s.root, "bin/tool")]
+	c.Assert(tool[1:4], DeepEquals, []string{"file", "0755", "11"})
+	c.Assert(tool[4], Not(Equals), "")
+	//uid, gid, inode and nlink are not recorded
+	c.Assert(tool[6:10], DeepEquals, []string{"", "", "", ""})
+	c.Assert(tool[10], HasLen, 128)
+	c.Assert(byPath[filepath.Join(s.root, "odd name\n")], NotNil)
+}
+
+func (s *ExportSuite) TestJSONL(c *C) {
+	lines := strings.Split(strings.TrimSuffix(s.export(c, ExportJSONL), "\n"), "\n")
+	c.Assert(lines, HasLen, 4)
+	byPath := make(map[string]map[string]interface{})
+	for _, l := range lines {
+		var rec map[string]interface{}
+		c.Assert(json.Unmarshal([]byte(l), &rec), IsNil)
+		byPath[rec["path"].(string)] = rec
+	}
+	tool := byPath[filepath.Join(s.root, "bin/tool")]
+	c.Assert(tool["size"], Equals, 11.0)
+	c.Assert(tool["mode"], Equals, "0755")
+	c.Assert(tool["attrs"], Equals, DefaultAttrs.String())
+	_, ok := tool["uid"]
+	c.Assert(ok, Equals, false)
+}
+
+func (s *ExportSuite) TestImportedDigestOnly(c *C) {
+	im := NewImporter(s.dbName)
+	im.SetRoot(s.root)
+	_, err := im.Read(ImportSums, strings.NewReader(sha256Abc+"  bin/tool\n"), "sums")
+	c.Assert(err, IsNil)
+	c.Assert(im.Commit(), IsNil)
+	tool := filepath.Join(s.root, "bin/tool")
+
+	c.Assert(s.export(c, ExportMtree), Equals, "#mtree\n."+tool+" type=file sha256digest="+sha256Abc+"\n")
+	rows, err := csv.NewReader(strings.NewReader(s.export(c, ExportCSV))).ReadAll()
+	c.Assert(err, IsNil)
+	c.Assert(rows, HasLen, 2)
+	c.Assert(rows[1], DeepEquals, []string{tool, "file", "", "", "", "", "", "", "", "", "", AttrSHA256.String(), "", "", sha256Abc, ""})
+	var rec map[string]interface{}
+	c.Assert(json.Unmarshal([]byte(s.export(c, ExportJSONL)), &rec), IsNil)
+	c.Assert(rec, DeepEquals, map[string]interface{}{"path": tool, "type": "file", "attrs": AttrSHA256.String(), "sha256": sha256Abc})
+}
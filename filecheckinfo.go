@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"crypto/sha512"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"time"
 )
@@ -19,9 +21,34 @@ type FileCheckInfo struct {
 	Mode    os.FileMode // file mode bits
 	ModTime time.Time   // modification time
 	Digest  []byte      // checksum
+	Attrs   Attr        // attributes recorded for (and checked on) this file
+	Uid     uint32      // owner user id
+	Gid     uint32      // owner group id
+	Inode   uint64      // inode number
+	Nlink   uint64      // number of hard links
+	CTime   time.Time   // inode change time
+	//checksum of the first PrefixLen bytes, recorded for growing files (AttrGrowing)
+	//so that appends can be told apart from rewrites
+	PrefixLen    int64
+	PrefixDigest []byte
+	Entropy      float64 // bits per byte of the content (0-8), recorded with AttrEntropy
 }
 
-//CalcDigest performs a SHA512 checksum on a file in question if it's a regular file
+//NewFileCheckInfo returns FileCheckInfo for path with metadata taken from info (as returned by os.Lstat)
+func NewFileCheckInfo(path string, info os.FileInfo) *FileCheckInfo {
+	fc := &FileCheckInfo{
+		Path:    path,
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+		Attrs:   DefaultAttrs,
+	}
+	fillStat(fc, info)
+	return fc
+}
+
+//CalcDigest performs a SHA512 checksum (or the one Attrs ask for) on a file in question if it's a regular file
+//with AttrEntropy the Entropy of the content is calculated along the way
 func (fc *FileCheckInfo) CalcDigest() error {
 	if !fc.Mode.IsRegular() || fc.Size == 0 {
 		//only calc regular files
@@ -33,11 +60,68 @@ func (fc *FileCheckInfo) CalcDigest() error {
 		return err
 	}
 	defer file.Close()
-	h := sha512.New()
-	if _, err := io.Copy(h, file); err != nil {
+	h := fc.Attrs.newHash()
+	if fc.Attrs&AttrEntropy == 0 {
+		if _, err := io.Copy(h, file); err != nil {
+			return err
+		}
+		fc.Digest = h.Sum(nil)
+		return nil
+	}
+	var bc byteCounter
+	if _, err := io.Copy(io.MultiWriter(h, &bc), file); err != nil {
 		return err
 	}
 	fc.Digest = h.Sum(nil)
+	fc.Entropy = bc.entropy()
+	return nil
+}
+
+//byteCounter is a Writer counting the occurrences of each byte value written
+type byteCounter struct {
+	counts [256]int64
+	total  int64
+}
+
+func (bc *byteCounter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		bc.counts[b]++
+	}
+	bc.total += int64(len(p))
+	return len(p), nil
+}
+
+//entropy returns the Shannon entropy of the bytes written in bits per byte, 8 for uniformly random data
+func (bc *byteCounter) entropy() float64 {
+	var e float64
+	for _, n := range bc.counts {
+		if n > 0 {
+			p := float64(n) / float64(bc.total)
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
+
+//CalcPrefixDigest performs a SHA512 checksum on the first n bytes of a regular file
+//PrefixLen is set to the number of bytes actually read, which is less than n if the file is shorter
+func (fc *FileCheckInfo) CalcPrefixDigest(n int64) error {
+	fc.PrefixLen, fc.PrefixDigest = 0, nil
+	if !fc.Mode.IsRegular() || n <= 0 {
+		return nil
+	}
+	file, err := os.Open(fc.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	h := sha512.New()
+	read, err := io.CopyN(h, file, n)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	fc.PrefixLen = read
+	fc.PrefixDigest = h.Sum(nil)
 	return nil
 }
 
@@ -51,6 +135,14 @@ func (fc *FileCheckInfo) HexDigest() string {
 	return fmt.Sprintf("%x", fc.Digest)
 }
 
+//digestAttr returns the content checksum Digest is
+func (fc *FileCheckInfo) digestAttr() Attr {
+	if a := fc.Attrs.digest(); a != 0 {
+		return a
+	}
+	return AttrSHA512
+}
+
 //MarshalBinary implements encoding/binary Marshaller
 func (fc *FileCheckInfo) MarshalBinary() ([]byte, error) {
 	var buf bytes.Buffer
@@ -76,6 +168,28 @@ func (fc *FileCheckInfo) MarshalBinary() ([]byte, error) {
 	blen = uint16(len(fc.Digest))
 	bw.Write(&buf, blen)
 	buf.Write(fc.Digest)
+	//attributes and ownership, not present in DBs written by older versions
+	bw.Write(&buf, uint32(fc.Attrs))
+	bw.Write(&buf, fc.Uid)
+	bw.Write(&buf, fc.Gid)
+	bw.Write(&buf, fc.Inode)
+	bw.Write(&buf, fc.Nlink)
+	sertime, err = fc.CTime.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	blen = uint16(len(sertime))
+	bw.Write(&buf, blen)
+	buf.Write(sertime)
+	//prefix digest
+	bw.Write(&buf, fc.PrefixLen)
+	blen = uint16(len(fc.PrefixDigest))
+	bw.Write(&buf, blen)
+	buf.Write(fc.PrefixDigest)
+	//entropy, only recorded with AttrEntropy
+	if fc.Attrs&AttrEntropy != 0 {
+		bw.Write(&buf, fc.Entropy)
+	}
 	return buf.Bytes(), bw.Err()
 }
 
@@ -116,20 +230,53 @@ func (fc *FileCheckInfo) UnmarshalBinary(datain []byte) error {
 	pos = pos + 2 // two bytes read for unit16
 	nextpos = pos + int(blen)
 	fc.Digest = br.Slice(data, pos, nextpos)
+	pos = nextpos
+	if br.Err() != nil || pos == len(data) {
+		//record of an older DB
+		fc.Attrs = DefaultAttrs
+		return br.Err()
+	}
+	byr.Seek(int64(pos), 0)
+	//attributes and ownership
+	var rawattrs uint32
+	br.Read(byr, &rawattrs)
+	fc.Attrs = Attr(rawattrs)
+	br.Read(byr, &fc.Uid)
+	br.Read(byr, &fc.Gid)
+	br.Read(byr, &fc.Inode)
+	br.Read(byr, &fc.Nlink)
+	pos = pos + 4 + 4 + 4 + 8 + 8
+	//ctime
+	br.Read(byr, &blen)
+	pos = pos + 2 // two bytes read for unit16
+	nextpos = pos + int(blen)
+	if err := (&fc.CTime).UnmarshalBinary(br.Slice(data, pos, nextpos)); err != nil && br.Err() == nil {
+		return err
+	}
+	pos = nextpos
+	if br.Err() != nil || pos == len(data) {
+		return br.Err()
+	}
+	byr.Seek(int64(pos), 0)
+	//prefix digest
+	br.Read(byr, &fc.PrefixLen)
+	br.Read(byr, &blen)
+	pos = pos + 8 + 2
+	nextpos = pos + int(blen)
+	fc.PrefixDigest = br.Slice(data, pos, nextpos)
+	pos = nextpos
+	if br.Err() != nil || pos == len(data) {
+		return br.Err()
+	}
+	byr.Seek(int64(pos), 0)
+	//entropy
+	br.Read(byr, &fc.Entropy)
 	return br.Err()
 }
 
 //LiteMatch is identical to match except it does not compare the digest checksum
 func (fc *FileCheckInfo) LiteMatch(ot *FileCheckInfo) bool {
-	if ot.Mode != fc.Mode {
-		return false
-	}
-	switch {
-	case fc.Mode.IsRegular():
-		return fc.Size == ot.Size && fc.ModTime.Equal(ot.ModTime)
-	default:
-		return fc.ModTime.Equal(ot.ModTime)
-	}
+	return len(fc.Diff(ot, DefaultAttrs&^AttrSHA512)) == 0
 }
 
 //Match returns true if this instance of FileCheckInfo equals the Other
@@ -143,6 +290,76 @@ func (fc *FileCheckInfo) Match(ot *FileCheckInfo) bool {
 	return ok
 }
 
+//Diff returns the names of attributes in attrs that differ between this instance and the Other (the older record)
+//size and digest only apply to regular files, a digest that appeared or disappeared is a digest change too
+func (fc *FileCheckInfo) Diff(ot *FileCheckInfo, attrs Attr) []string {
+	var diff []string
+	regular := fc.Mode.IsRegular() && ot.Mode.IsRegular()
+	if attrs&AttrPerm != 0 && fc.Mode != ot.Mode {
+		diff = append(diff, "mode")
+	}
+	if attrs&AttrInode != 0 && fc.Inode != ot.Inode {
+		diff = append(diff, "inode")
+	}
+	if attrs&AttrLinks != 0 && fc.Nlink != ot.Nlink {
+		diff = append(diff, "links")
+	}
+	if attrs&AttrUser != 0 && fc.Uid != ot.Uid {
+		diff = append(diff, "user")
+	}
+	if attrs&AttrGroup != 0 && fc.Gid != ot.Gid {
+		diff = append(diff, "group")
+	}
+	switch {
+	case !regular:
+	case attrs&AttrSize != 0 && fc.Size != ot.Size:
+		diff = append(diff, "size")
+	case attrs&AttrGrowing != 0 && ot.Inode != 0 && fc.Inode != ot.Inode:
+		//replaced by a different file
+		diff = append(diff, "rotated")
+	case attrs&AttrGrowing != 0 && fc.Size < ot.Size:
+		diff = append(diff, "shrunk")
+	}
+	if attrs&AttrMtime != 0 && !fc.ModTime.Equal(ot.ModTime) {
+		diff = append(diff, "mtime")
+	}
+	if attrs&AttrCtime != 0 && !fc.CTime.Equal(ot.CTime) {
+		diff = append(diff, "ctime")
+	}
+	if attrs&AttrContent != 0 && regular && !bytes.Equal(fc.Digest, ot.Digest) {
+		diff = append(diff, "digest")
+	}
+	return diff
+}
+
+//MarshalJSON implements json.Marshaler
+func (fc *FileCheckInfo) MarshalJSON() ([]byte, error) {
+	digests := make(map[Attr]string)
+	if len(fc.Digest) > 0 {
+		digests[fc.digestAttr()] = fmt.Sprintf("%x", fc.Digest)
+	}
+	return json.Marshal(struct {
+		Path    string    `json:"path"`
+		Size    int64     `json:"size"`
+		Mode    string    `json:"mode"`
+		ModTime time.Time `json:"mtime"`
+		Digest  string    `json:"sha512,omitempty"`
+		SHA256  string    `json:"sha256,omitempty"`
+		MD5     string    `json:"md5,omitempty"`
+		Attrs   string    `json:"attrs"`
+		Uid     uint32    `json:"uid"`
+		Gid     uint32    `json:"gid"`
+		Inode   uint64    `json:"inode"`
+		Nlink   uint64    `json:"nlink"`
+		CTime   time.Time `json:"ctime"`
+		PLen    int64     `json:"prefix_len,omitempty"`
+		PDigest string    `json:"prefix_sha512,omitempty"`
+		Entropy float64   `json:"entropy,omitempty"`
+	}{fc.Path, fc.Size, fc.Mode.String(), fc.ModTime, digests[AttrSHA512], digests[AttrSHA256], digests[AttrMD5],
+		fc.Attrs.String(), fc.Uid, fc.Gid, fc.Inode, fc.Nlink, fc.CTime,
+		fc.PrefixLen, fmt.Sprintf("%x", fc.PrefixDigest), fc.Entropy})
+}
+
 type binaryWriter struct {
 	err error
 }
@@ -82,3 +82,61 @@ func (s *FileCheckInfoSuite) TestMatching(c *C) {
 	fc.Digest = []byte("boo")
 	c.Assert(fc.Match(&fc2), Equals, true)
 }
+
+func (s *FileCheckInfoSuite) TestAttributesRoundTrip(c *C) {
+	fc := FileCheckInfo{
+		Path:         "/made/up",
+		Size:         13,
+		ModTime:      time.Unix(1000, 0),
+		Digest:       []byte("somesuch"),
+		Attrs:        AttrPerm | AttrUser | AttrGrowing,
+		Uid:          1000,
+		Gid:          100,
+		Inode:        1 << 40,
+		Nlink:        2,
+		CTime:        time.Unix(2000, 5),
+		PrefixLen:    13,
+		PrefixDigest: []byte("prefix"),
+	}
+	data, err := fc.MarshalBinary()
+	c.Assert(err, IsNil)
+	rfc := &FileCheckInfo{}
+	c.Assert(rfc.UnmarshalBinary(data), IsNil)
+	c.Assert(rfc.Attrs, Equals, fc.Attrs)
+	c.Assert(rfc.Uid, Equals, fc.Uid)
+	c.Assert(rfc.Gid, Equals, fc.Gid)
+	c.Assert(rfc.Inode, Equals, fc.Inode)
+	c.Assert(rfc.Nlink, Equals, fc.Nlink)
+	c.Assert(rfc.CTime.Equal(fc.CTime), Equals, true)
+	c.Assert(rfc.PrefixLen, Equals, fc.PrefixLen)
+	c.Assert(string(rfc.PrefixDigest), Equals, "prefix")
+}
+
+func (s *FileCheckInfoSuite) TestOlderRecord(c *C) {
+	//records of older DBs end right after the digest
+	fc := FileCheckInfo{Path: "/made/up", Size: 1, ModTime: time.Unix(1000, 0), Digest: []byte("abc")}
+	data, err := fc.MarshalBinary()
+	c.Assert(err, IsNil)
+	mtime, _ := fc.ModTime.MarshalBinary()
+	data = data[:2+len(fc.Path)+8+4+2+len(mtime)+2+len(fc.Digest)]
+	rfc := &FileCheckInfo{}
+	c.Assert(rfc.UnmarshalBinary(data), IsNil)
+	c.Assert(rfc.Path, Equals, fc.Path)
+	c.Assert(string(rfc.Digest), Equals, "abc")
+	c.Assert(rfc.Attrs, Equals, DefaultAttrs)
+}
+
+func (s *FileCheckInfoSuite) TestDiffAttrs(c *C) {
+	old := &FileCheckInfo{Path: "/log", Size: 10, Mode: 0644, ModTime: time.Unix(1, 0), Uid: 1}
+	cur := &FileCheckInfo{Path: "/log", Size: 20, Mode: 0644, ModTime: time.Unix(2, 0), Uid: 1}
+	c.Assert(cur.Diff(old, DefaultAttrs), DeepEquals, []string{"size", "mtime"})
+	c.Assert(cur.Diff(old, AttrPerm|AttrUser|AttrGrowing), HasLen, 0)
+	c.Assert(old.Diff(cur, AttrPerm|AttrUser|AttrGrowing), DeepEquals, []string{"shrunk"})
+	cur.Uid = 0
+	c.Assert(cur.Diff(old, AttrUser|AttrGroup), DeepEquals, []string{"user"})
+	cur.Digest = []byte("abc")
+	c.Assert(cur.Diff(old, AttrSHA512), DeepEquals, []string{"digest"})
+	c.Assert(old.Diff(cur, AttrSHA512), DeepEquals, []string{"digest"})
+	old.Digest = []byte("abc")
+	c.Assert(cur.Diff(old, AttrSHA512), HasLen, 0)
+}
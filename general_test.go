@@ -3,6 +3,7 @@ package fcheck
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"os"
 	"strings"
 	"sync"
@@ -26,10 +27,10 @@ func (s *TestSuite) TearDownSuite(c *C) {
 
 func (s *TestSuite) Test1Generator(c *C) {
 	var g Walker = NewGenerator(s.testDBName, 2, false)
-	exclude := make(StringSet)
+	exclude := NewMatcher()
 	err := g.Start()
 	c.Assert(err, IsNil)
-	err = g.StartWalking(s.testPath, exclude)
+	err = g.StartWalking(context.Background(), []string{s.testPath}, exclude)
 	c.Assert(err, IsNil)
 	err = g.Stop()
 	c.Assert(err, IsNil)
@@ -37,14 +38,14 @@ func (s *TestSuite) Test1Generator(c *C) {
 
 func (s *TestSuite) Test2Printer(c *C) {
 	var p Walker = NewPrinter(s.testDBName)
-	exclude := make(StringSet)
-	exclude.Add("/bin/ps")
+	exclude := NewMatcher()
+	c.Assert(exclude.Add("/bin/ps"), IsNil)
 	var buf bytes.Buffer
 	err := p.Start()
 	c.Assert(err, IsNil)
 	rawp := p.(*Printer)
 	rawp.console = &buf
-	err = p.StartWalking(s.testPath, exclude)
+	err = p.StartWalking(context.Background(), []string{s.testPath}, exclude)
 	c.Assert(err, IsNil)
 	err = p.Stop()
 	c.Assert(err, IsNil)
@@ -69,10 +70,10 @@ func (s *TestSuite) Test3Comparator(c *C) {
 	rawcm := cm.(*Comparator)
 	var buf bytes.Buffer
 	rawcm.console = &buf
-	exclude := make(StringSet)
+	exclude := NewMatcher()
 	err := cm.Start()
 	c.Assert(err, IsNil)
-	err = cm.StartWalking(s.testPath, exclude)
+	err = cm.StartWalking(context.Background(), []string{s.testPath}, exclude)
 	c.Assert(err, IsNil)
 	err = cm.Stop()
 	c.Assert(err, IsNil)
@@ -86,11 +87,11 @@ func (s *TestSuite) Test4ComparatorNoPath(c *C) {
 	rawcm := cm.(*Comparator)
 	var buf bytes.Buffer
 	rawcm.console = &buf
-	exclude := make(StringSet)
+	exclude := NewMatcher()
 	err := cm.Start()
 	c.Assert(err, IsNil)
 	//non-exist path
-	err = cm.StartWalking("/foobardubar23256646", exclude)
+	err = cm.StartWalking(context.Background(), []string{"/foobardubar23256646"}, exclude)
 	c.Assert(err, IsNil)
 	err = cm.Stop()
 	c.Assert(err, IsNil)
@@ -104,11 +105,11 @@ func (s *TestSuite) Test5ComparatorNoPathInDB(c *C) {
 	rawcm := cm.(*Comparator)
 	var buf bytes.Buffer
 	rawcm.console = &buf
-	exclude := make(StringSet)
+	exclude := NewMatcher()
 	err := cm.Start()
 	c.Assert(err, IsNil)
 	//permission errors as ordinary user plus new files
-	err = cm.StartWalking("/etc", exclude)
+	err = cm.StartWalking(context.Background(), []string{"/etc"}, exclude)
 	c.Assert(err, IsNil)
 	err = cm.Stop()
 	c.Assert(err, IsNil)
@@ -119,14 +120,14 @@ func (s *TestSuite) Test5ComparatorNoPathInDB(c *C) {
 
 func (s *TestSuite) Test6PrinterNoPath(c *C) {
 	var cm Walker = NewPrinter(s.testDBName)
-	exclude := make(StringSet)
+	exclude := NewMatcher()
 	rawcm := cm.(*Printer)
 	var buf bytes.Buffer
 	rawcm.console = &buf
 	err := cm.Start()
 	c.Assert(err, IsNil)
 	//non-exist path
-	err = cm.StartWalking("/foobardubar23256646", exclude)
+	err = cm.StartWalking(context.Background(), []string{"/foobardubar23256646"}, exclude)
 	c.Assert(err, IsNil)
 	err = cm.Stop()
 	c.Assert(err, IsNil)
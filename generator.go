@@ -1,74 +1,218 @@
 package fcheck
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
+	"sort"
+	"sync"
+	"time"
 )
 
 //Generator represents a file system walker that generates meta db of files it sees on the system
 type Generator struct {
 	numWorker int
 	FileInfoWriter
-	excludes []string
+	excludes *walkScope
 	sem      chan int
 	verbose  bool
+	errCount int
+	policy   *Policy
+	progress *Progress
+	dbfile   string
+	ctx      context.Context
+	timer    checkpointTimer
+	resume   *Checkpoint
+	roots    PathPrefixes
+	curRoot  int       // index of the root being walked
+	created  time.Time // of the DB header
+	stopPath string    // where the walk was interrupted
+	stopped  bool      // the walk was interrupted by the context
+	history  *History
+	label    string
+	iocs     *HashList
+	l        sync.Mutex
+	iocHits  []*Finding
 }
 
 //NewGenerator returns new Generator instance backed by the DB in dbfname
+//an existing DB is replaced only once the new one is complete
 func NewGenerator(dbfname string, num int, verbose bool) *Generator {
-	if err := os.Remove(dbfname); err != nil {
-		log.Printf("Trouble removing old db file %s: %s\n", dbfname, err)
-	}
 	return &Generator{
 		numWorker:      num,
 		FileInfoWriter: NewDBWriter(dbfname),
+		dbfile:         dbfname,
 		verbose:        verbose}
 }
 
-//StartWalking starts the actual walking of the filesystem to generate the DB
-func (g *Generator) StartWalking(path string, exclude StringSet) error {
-	g.excludes = exclude.Items()
-	return filepath.Walk(path, g.Walk)
+//SetPolicy sets the Policy deciding which attributes are recorded for each path, by default DefaultAttrs are
+func (g *Generator) SetPolicy(p *Policy) {
+	g.policy = p
 }
 
-//Walk is the implemention of filepath.WalkFunc meant to be passed to filepath.Walk
-func (g *Generator) Walk(path string, info os.FileInfo, err error) error {
-	for _, v := range g.excludes {
-		if strings.HasPrefix(path, v) {
-			//path is excluded
-			if info.IsDir() {
-				return filepath.SkipDir
+//SetProgress sets the Progress updated while generating
+//the total is taken from the DB being replaced or else counted by walking the filesystem ahead, in the background
+func (g *Generator) SetProgress(p *Progress) {
+	g.progress = p
+}
+
+//SetHistory makes the Generator keep the DB it replaces as a previous generation in h
+func (g *Generator) SetHistory(h *History) {
+	g.history = h
+}
+
+//SetHashList sets the known-bad checksums the files are matched against as they are recorded, see IOCMatches
+func (g *Generator) SetHashList(h *HashList) {
+	g.iocs = h
+}
+
+//IOCMatches returns the files whose content was found on the known-bad hash list, sorted by path
+func (g *Generator) IOCMatches() []*Finding {
+	g.l.Lock()
+	defer g.l.Unlock()
+	hits := make([]*Finding, len(g.iocHits))
+	copy(hits, g.iocHits)
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Path < hits[j].Path })
+	return hits
+}
+
+//SetLabel sets the label recorded in the DB header, to tell generations apart
+func (g *Generator) SetLabel(label string) {
+	g.label = label
+}
+
+//SetCheckpoint makes the Generator save a Checkpoint to file every interval (unless 0) and when interrupted
+//the DB written so far is then kept, so that the run can be resumed
+func (g *Generator) SetCheckpoint(file string, every time.Duration) {
+	g.timer = checkpointTimer{file: file, every: every, last: time.Now()}
+}
+
+//Resume makes the Generator continue the run cp was taken of, appending to the DB written so far
+//it has to be called before Start and the same roots have to be walked
+func (g *Generator) Resume(cp *Checkpoint) {
+	g.resume = cp
+}
+
+//StartWalking starts the actual walking of the filesystem to generate the DB, the roots are recorded in the DB header
+//if ctx is cancelled the walk stops and Stop discards the DB
+func (g *Generator) StartWalking(ctx context.Context, roots []string, exclude *Matcher) error {
+	g.ctx = ctx
+	g.excludes = exclude.newScope(roots)
+	walk := g.excludes.roots
+	if len(walk) == 0 {
+		return errNoRoots
+	}
+	g.roots = walk
+	if g.resume != nil {
+		if err := g.resume.checkResume(checkpointGenerate, walk); err != nil {
+			return err
+		}
+		g.errCount = g.resume.ErrCount
+	} else {
+		g.created = time.Now()
+		if err := g.PutHeader(&DBHeader{Roots: walk, Created: g.created, Label: g.label}); err != nil {
+			return err
+		}
+	}
+	if g.progress != nil && g.progress.Total() == 0 {
+		//a count still going is of no use once the walk is over
+		countCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			n, err := countDB(g.dbfile, walk)
+			if err != nil {
+				n = countWalk(countCtx, walk, exclude)
+			}
+			if countCtx.Err() == nil {
+				g.progress.SetTotal(n)
+			}
+		}()
+	}
+	for i, root := range walk {
+		g.curRoot = i
+		if err := filepath.Walk(root, g.Walk); err != nil {
+			if err == ctx.Err() {
+				g.stopped = true
+				if g.timer.enabled() {
+					g.checkpoint(g.stopPath)
+				}
 			}
-			return nil
+			return err
 		}
 	}
+	return nil
+}
+
+//checkpoint waits for the entries walked so far to be written and saves a Checkpoint with next as the first path not done
+func (g *Generator) checkpoint(next string) {
+	for i := 0; i < g.numWorker; i++ {
+		g.sem <- 1
+	}
+	defer func() {
+		for i := 0; i < g.numWorker; i++ {
+			<-g.sem
+		}
+	}()
+	written, err := g.FileInfoWriter.(CheckpointWriter).Sync()
+	if err == nil {
+		cp := &Checkpoint{Kind: checkpointGenerate, DB: DBStamp{Created: g.created}, Roots: g.roots, Root: g.curRoot,
+			Next: next, Written: written, ErrCount: g.errCount}
+		err = cp.save(g.timer.file)
+	}
+	if err != nil {
+		log.Printf("Trouble saving checkpoint: %s\n", err)
+	}
+}
+
+//Walk is the implemention of filepath.WalkFunc meant to be passed to filepath.Walk
+func (g *Generator) Walk(path string, info os.FileInfo, err error) error {
+	if cerr := g.ctx.Err(); cerr != nil {
+		g.stopPath = path
+		return cerr
+	}
+	if skip, serr := g.resume.skip(g.curRoot, path, info); skip {
+		return serr
+	}
+	if g.timer.due() {
+		g.checkpoint(path)
+	}
+	if excluded, skip := g.excludes.excluded(path, info); excluded {
+		return skip
+	}
 	if err != nil {
 		log.Printf("Trouble in Generator.Walk: %s\n", err)
+		g.errCount++
+		g.progress.entryDone()
 		return nil
 	}
 	g.sem <- 1
-	if g.verbose && info.IsDir() {
-		fmt.Printf("Entering %s\n", path)
+	if info.IsDir() {
+		g.progress.enter(path)
+		if g.verbose {
+			fmt.Printf("Entering %s\n", path)
+		}
 	}
 	go func() {
 		defer func() { <-g.sem }()
-		fc := &FileCheckInfo{
-			Path:    path,
-			Size:    info.Size(),
-			Mode:    info.Mode(),
-			ModTime: info.ModTime(),
-		}
+		defer g.progress.entryDone()
+		fc := NewFileCheckInfo(path, info)
+		fc.Attrs = g.policy.Attrs(path, fc.Mode)
 		g.saveFc(fc)
 	}()
 	return nil
 }
 
 func (g *Generator) saveFc(fc *FileCheckInfo) {
-	if err := fc.CalcDigest(); err != nil {
-		log.Printf("Trouble calculating digest %s: %s\n", fc.Path, err)
+	recordContent(fc, g.progress)
+	if label, ok, err := g.iocs.Match(fc, true); err != nil {
+		log.Printf("Trouble matching %s against hash lists: %s\n", fc.Path, err)
+	} else if ok {
+		log.Printf("Known bad file %s (%s)\n", fc.Path, label)
+		g.l.Lock()
+		g.iocHits = append(g.iocHits, &Finding{Kind: KindIOC, Severity: SeverityCritical, Path: fc.Path, New: fc, Indicator: label})
+		g.l.Unlock()
 	}
 	err := g.Put(fc)
 	if err != nil {
@@ -76,17 +220,80 @@ func (g *Generator) saveFc(fc *FileCheckInfo) {
 	}
 }
 
+//recordContent calculates the digests fc.Attrs ask for, failures are logged and leave them empty
+func recordContent(fc *FileCheckInfo, progress *Progress) {
+	if fc.Attrs&(AttrContent|AttrEntropy) != 0 {
+		if err := fc.CalcDigest(); err != nil {
+			log.Printf("Trouble calculating digest %s: %s\n", fc.Path, err)
+		} else if len(fc.Digest) > 0 {
+			progress.hashed(fc.Size)
+		}
+	}
+	if fc.Attrs&AttrGrowing != 0 {
+		//remember what was there so far, a later check verifies it is unchanged
+		if err := fc.CalcPrefixDigest(fc.Size); err != nil {
+			log.Printf("Trouble calculating prefix digest %s: %s\n", fc.Path, err)
+		}
+		progress.hashed(fc.PrefixLen)
+	}
+}
+
+//WalkErrors returns the number of filesystem entries that could not be walked
+func (g *Generator) WalkErrors() int {
+	return g.errCount
+}
+
 //Start initializes generator before walking (e.g. start workers, open DB)
 func (g *Generator) Start() error {
 	g.sem = make(chan int, g.numWorker)
-	return g.FileInfoWriter.Start()
+	if !g.timer.enabled() && g.resume == nil {
+		return g.FileInfoWriter.Start()
+	}
+	cw, ok := g.FileInfoWriter.(CheckpointWriter)
+	if !ok {
+		return fmt.Errorf("checkpoints are not supported by %T", g.FileInfoWriter)
+	}
+	if g.resume == nil {
+		return g.FileInfoWriter.Start()
+	}
+	//make sure it is the DB the checkpoint was taken writing
+	r := NewDBReader(tempDBName(g.dbfile))
+	if err := r.Start(); err != nil {
+		return err
+	}
+	h := r.Header()
+	r.Stop()
+	if h == nil || g.resume.Kind != checkpointGenerate || !h.Created.Equal(g.resume.DB.Created) {
+		return fmt.Errorf("%s is not the db the checkpoint was taken writing", tempDBName(g.dbfile))
+	}
+	g.created = h.Created
+	return cw.StartAt(g.resume.Written)
 }
 
 //Stop cleans up after generator finished walking (e.g. wait for pending operation, close DB)
+//if walking was interrupted the incomplete DB is discarded and the previous one kept, unless checkpoints are
+//saved in which case it is kept to be resumed
 func (g *Generator) Stop() error {
 	//wait for workers to finish
 	for i := 0; i < g.numWorker; i++ {
 		g.sem <- 1
 	}
-	return g.FileInfoWriter.Stop()
+	if g.stopped {
+		if g.timer.enabled() {
+			return g.FileInfoWriter.(CheckpointWriter).Suspend()
+		}
+		return g.Abort()
+	}
+	if g.history != nil {
+		if err := g.history.Archive(); err != nil {
+			g.Abort()
+			return err
+		}
+	}
+	err := g.FileInfoWriter.Stop()
+	if err == nil && g.timer.enabled() {
+		//nothing left to resume
+		os.Remove(g.timer.file)
+	}
+	return err
 }
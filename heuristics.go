@@ -0,0 +1,121 @@
+package fcheck
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//Reasons Heuristics tags findings with
+const (
+	SuspiciousSetuid        = "setuid"         // a new setuid or setgid file, or one that gained the bit
+	SuspiciousWorldWritable = "world-writable" // writable by anyone, in a system directory
+	SuspiciousTimestomp     = "timestomp"      // modification time set back, or much older than the inode change time
+	SuspiciousTempExec      = "tmp-exec"       // executable in a temporary directory
+	SuspiciousHidden        = "hidden"         // hidden file or below a hidden directory, in a system directory
+	SuspiciousControlChars  = "control-chars"  // control characters in the path
+)
+
+//DefaultTimestompMargin is how much older than the inode change time a modification time has to be to look forged
+const DefaultTimestompMargin = 30 * 24 * time.Hour
+
+var (
+	//systemDirs are where world-writable and hidden files are suspicious
+	systemDirs = []string{"/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32", "/usr", "/etc", "/boot", "/opt"}
+	//tempDirs are where anyone can drop files, executables there are suspicious
+	tempDirs = []string{"/tmp", "/var/tmp", "/dev/shm", "/run/shm"}
+	//hiddenOK are hidden paths in system directories put there by the system itself
+	hiddenOK = []string{"/etc/skel", "/etc/.pwd.lock", "/etc/.updated", "/usr/lib/.build-id", "/usr/lib/debug/.build-id"}
+)
+
+//Heuristics is an Annotator tagging new, changed, moved and copied files that look like an intrusion with the
+//reasons why (see Finding.Suspicious), on top of what changed
+type Heuristics struct {
+	systemDirs PathPrefixes
+	tempDirs   PathPrefixes
+	hiddenOK   PathPrefixes
+	margin     time.Duration
+}
+
+//NewHeuristics returns new Heuristics with the builtin system and temporary directories and DefaultTimestompMargin
+func NewHeuristics() *Heuristics {
+	return &Heuristics{
+		systemDirs: NewPathPrefixes(systemDirs...),
+		tempDirs:   NewPathPrefixes(tempDirs...),
+		hiddenOK:   NewPathPrefixes(hiddenOK...),
+		margin:     DefaultTimestompMargin,
+	}
+}
+
+//SetTimestompMargin sets how much older than the inode change time a modification time has to be to look forged,
+//0 only leaves changed files whose modification time was set back
+func (h *Heuristics) SetTimestompMargin(d time.Duration) {
+	h.margin = d
+}
+
+//Annotate implements Annotator
+func (h *Heuristics) Annotate(f *Finding) {
+	if f.New == nil {
+		return
+	}
+	switch f.Kind {
+	case KindNew, KindChanged, KindMoved, KindCopied:
+	default:
+		return
+	}
+	fc := f.New
+	path := filepath.Clean(f.Path)
+	var reasons []string
+	if fc.Mode.IsRegular() && gainedSetuid(f) {
+		reasons = append(reasons, SuspiciousSetuid)
+	}
+	system := h.systemDirs.Match(path)
+	if system && fc.Mode&0002 != 0 && fc.Mode&(os.ModeSymlink|os.ModeSticky) == 0 {
+		reasons = append(reasons, SuspiciousWorldWritable)
+	}
+	if h.timestomped(f) {
+		reasons = append(reasons, SuspiciousTimestomp)
+	}
+	if fc.Mode.IsRegular() && fc.Mode&0111 != 0 && h.tempDirs.Match(path) {
+		reasons = append(reasons, SuspiciousTempExec)
+	}
+	if system && !h.hiddenOK.Match(path) && hasHiddenElement(path[len(h.systemDirs.Longest(path)):]) {
+		reasons = append(reasons, SuspiciousHidden)
+	}
+	if strings.IndexFunc(path, unicode.IsControl) >= 0 {
+		reasons = append(reasons, SuspiciousControlChars)
+	}
+	f.Suspicious = reasons
+}
+
+//timestomped returns true if the modification time of f looks forged: set back on a changed file, or older than the
+//inode change time by more than the margin, for changed files only if the modification time did not move on
+//(the content was changed and the old mtime put back, an update moves it to the time of the new build)
+func (h *Heuristics) timestomped(f *Finding) bool {
+	fc := f.New
+	if f.Shipped == ShippedMatch {
+		//files the package shipped keep the mtime of the build
+		return false
+	}
+	if f.Kind == KindChanged && f.Old != nil {
+		if fc.ModTime.Before(f.Old.ModTime) {
+			return true
+		}
+		if fc.ModTime.After(f.Old.ModTime) {
+			return false
+		}
+	}
+	return h.margin > 0 && !fc.CTime.IsZero() && fc.CTime.Sub(fc.ModTime) > h.margin
+}
+
+//hasHiddenElement returns true if an element of path starts with a dot
+func hasHiddenElement(path string) bool {
+	for _, v := range strings.Split(path, string(filepath.Separator)) {
+		if strings.HasPrefix(v, ".") {
+			return true
+		}
+	}
+	return false
+}
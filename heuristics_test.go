@@ -0,0 +1,83 @@
+package fcheck
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type HeuristicsSuite struct{}
+
+var _ = Suite(&HeuristicsSuite{})
+
+func (s *HeuristicsSuite) TestAnnotate(c *C) {
+	now := time.Now()
+	month := DefaultTimestompMargin
+	h := NewHeuristics()
+	tests := []struct {
+		kind    string
+		path    string
+		mode    os.FileMode
+		old     os.FileMode
+		mtime   time.Time
+		reasons []string
+	}{
+		{KindNew, "/usr/bin/tool", os.ModeSetuid | 0755, 0, now, []string{SuspiciousSetuid}},
+		{KindChanged, "/usr/bin/passwd", os.ModeSetuid | 0755, os.ModeSetuid | 0755, now, nil},
+		{KindChanged, "/usr/bin/ls", 0755, 0755, now.Add(-4 * month), []string{SuspiciousTimestomp}},
+		{KindChanged, "/usr/bin/cp", 0755, 0755, now.Add(-2 * month), nil},
+		{KindChanged, "/usr/bin/ssh", 0755, 0755, now.Add(-3 * month), []string{SuspiciousTimestomp}},
+		{KindChanged, "/usr/bin/tool", os.ModeSetgid | 0755, 0755, now, []string{SuspiciousSetuid}},
+		{KindNew, "/etc/cron.d/job", 0666, 0, now, []string{SuspiciousWorldWritable}},
+		{KindNew, "/home/bob/notes", 0666, 0, now, nil},
+		{KindNew, "/usr/lib/libc.so.6", 0755, 0, now.Add(-2 * month), []string{SuspiciousTimestomp}},
+		{KindNew, "/tmp/x/.cache/run", 0700, 0, now, []string{SuspiciousTempExec}},
+		{KindNew, "/var/tmp/data", 0600, 0, now, nil},
+		{KindNew, "/usr/lib/.x/mod.so", 0644, 0, now, []string{SuspiciousHidden}},
+		{KindNew, "/etc/skel/.bashrc", 0644, 0, now, nil},
+		{KindNew, "/usr/share/a\x1bb", 0644, 0, now, []string{SuspiciousControlChars}},
+		{KindRemoved, "/usr/lib/.x", os.ModeDir | 0777, 0, now, nil},
+	}
+	for _, t := range tests {
+		f := &Finding{Kind: t.kind, Path: t.path}
+		if t.kind != KindRemoved {
+			f.New = &FileCheckInfo{Path: t.path, Mode: t.mode, ModTime: t.mtime, CTime: now}
+		}
+		if t.kind == KindChanged {
+			//changed files only look timestomped when their mtime went back
+			f.Old = &FileCheckInfo{Path: t.path, Mode: t.old, ModTime: now.Add(-3 * month)}
+		}
+		h.Annotate(f)
+		c.Check(f.Suspicious, DeepEquals, t.reasons, Commentf("path %q", t.path))
+	}
+
+	//packaged files keep the mtime of their build
+	f := &Finding{Kind: KindNew, Path: "/usr/lib/libc.so.6", Shipped: ShippedMatch,
+		New: &FileCheckInfo{Mode: 0644, ModTime: now.Add(-2 * month), CTime: now}}
+	h.Annotate(f)
+	c.Assert(f.Suspicious, IsNil)
+	//without a margin only a modification time set back is
+	f.Shipped = ""
+	h.SetTimestompMargin(0)
+	h.Annotate(f)
+	c.Assert(f.Suspicious, IsNil)
+}
+
+func (s *HeuristicsSuite) TestSeverity(c *C) {
+	f := &Finding{Kind: KindNew, Path: "/usr/bin/tool", Suspicious: []string{SuspiciousSetuid}}
+	var nilp *Policy
+	c.Assert(nilp.Severity(f), Equals, SeverityHigh)
+	c.Assert(f.note(), Equals, " [suspicious: setuid]")
+	f.Package, f.Shipped = "tool", ShippedDiffer
+	c.Assert(f.note(), Equals, " [tool, not as shipped; suspicious: setuid]")
+
+	p, err := ParsePolicy(strings.NewReader("severity=low suspicious /\nseverity=critical suspicious=setuid,tmp-exec /usr\n"))
+	c.Assert(err, IsNil)
+	c.Assert(p.Severity(f), Equals, SeverityCritical)
+	f.Suspicious = []string{SuspiciousHidden}
+	c.Assert(p.Severity(f), Equals, SeverityLow)
+	f.Suspicious = nil
+	c.Assert(p.Severity(f), Equals, DefaultSeverity)
+}
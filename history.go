@@ -0,0 +1,235 @@
+package fcheck
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//generationLayout names the files of previous generations after when they were created, so they sort oldest first
+const generationLayout = "20060102T150405.000000000Z"
+
+//History keeps previous generations of a DB in a directory next to it (the DB file name plus .history),
+//so that a check can be run against any of them and a file can be followed through them
+type History struct {
+	dbfile string
+	dir    string
+	keep   int // generations retained including the current DB, 0 for all
+}
+
+//Generation is one DB of a History
+type Generation struct {
+	ID     int // 0 is the current DB, 1 the one before it and so on
+	File   string
+	Header *DBHeader // nil for DBs written without one
+}
+
+//FileVersion is the record of a file in a Generation, Record is nil if the file was not in it
+type FileVersion struct {
+	Generation
+	Record *FileCheckInfo
+}
+
+//NewHistory returns the History of the DB in dbfname retaining keep generations (0 for all)
+func NewHistory(dbfname string, keep int) *History {
+	return &History{dbfile: dbfname, dir: dbfname + ".history", keep: keep}
+}
+
+//readHeader returns the header of the DB in fname, nil if it has none
+func readHeader(fname string) (*DBHeader, error) {
+	r := NewDBReader(fname)
+	if err := r.Start(); err != nil {
+		return nil, err
+	}
+	h := r.Header()
+	return h, r.Stop()
+}
+
+//Archive keeps the current DB as a previous generation, it is called before the DB is replaced
+//the oldest generations beyond those retained are removed
+func (h *History) Archive() error {
+	fi, err := os.Stat(h.dbfile)
+	if os.IsNotExist(err) {
+		//nothing to keep yet
+		return nil
+	} else if err != nil {
+		return err
+	}
+	created := fi.ModTime()
+	hdr, err := readHeader(h.dbfile)
+	if err != nil {
+		return err
+	}
+	if hdr != nil {
+		created = hdr.Created
+	}
+	if err = os.MkdirAll(h.dir, 0755); err != nil {
+		return err
+	}
+	name := filepath.Join(h.dir, created.UTC().Format(generationLayout)+".db")
+	if _, err = os.Stat(name); os.IsNotExist(err) {
+		if err = linkOrCopy(h.dbfile, name); err != nil {
+			return err
+		}
+	}
+	return h.prune()
+}
+
+//prune removes the oldest previous generations beyond keep
+func (h *History) prune() error {
+	if h.keep <= 0 {
+		return nil
+	}
+	files, err := h.files()
+	if err != nil {
+		return err
+	}
+	//the DB about to be written is the current one
+	for len(files) > h.keep-1 {
+		if err = os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+//files returns the files of the previous generations, oldest first
+func (h *History) files() ([]string, error) {
+	entries, err := ioutil.ReadDir(h.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if e.Mode().IsRegular() && strings.HasSuffix(e.Name(), ".db") {
+			files = append(files, filepath.Join(h.dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+//linkOrCopy makes dst a hard link of src, or a copy where that is not possible
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+//Generations returns all generations, the oldest first and the current DB last
+func (h *History) Generations() ([]Generation, error) {
+	files, err := h.files()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(h.dbfile); err == nil {
+		files = append(files, h.dbfile)
+	}
+	gens := make([]Generation, 0, len(files))
+	for i, f := range files {
+		hdr, err := readHeader(f)
+		if err != nil {
+			return nil, err
+		}
+		gens = append(gens, Generation{ID: len(files) - 1 - i, File: f, Header: hdr})
+	}
+	return gens, nil
+}
+
+//Resolve returns the generation sel selects, either by ID (0 is the current DB) or by label (the newest with it)
+func (h *History) Resolve(sel string) (*Generation, error) {
+	gens, err := h.Generations()
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.Atoi(sel)
+	for i := len(gens) - 1; i >= 0; i-- {
+		g := gens[i]
+		if err == nil && g.ID == id || err != nil && g.Header != nil && g.Header.Label == sel {
+			return &g, nil
+		}
+	}
+	return nil, fmt.Errorf("no generation %q of %s", sel, h.dbfile)
+}
+
+//FileHistory returns the records of path in all generations, the oldest first
+func (h *History) FileHistory(path string) ([]FileVersion, error) {
+	gens, err := h.Generations()
+	if err != nil {
+		return nil, err
+	}
+	path = filepath.Clean(path)
+	versions := make([]FileVersion, 0, len(gens))
+	for _, g := range gens {
+		v := FileVersion{Generation: g}
+		r := NewDBReader(g.File)
+		if err := r.Start(); err != nil {
+			return nil, err
+		}
+		err := r.Map(path, func(fc *FileCheckInfo) error {
+			if fc.Path == path {
+				v.Record = fc
+			}
+			return nil
+		})
+		r.Stop()
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+//WriteFileHistory writes one line per version to w, with the attributes that differ from the version before
+func WriteFileHistory(w io.Writer, versions []FileVersion) error {
+	const layout = "2006-01-02 15:04:05 (MST)"
+	var prev *FileCheckInfo
+	for i, v := range versions {
+		created, label := "-", ""
+		if v.Header != nil {
+			created, label = v.Header.Created.Format(layout), v.Header.Label
+		}
+		desc := "absent"
+		if fc := v.Record; fc != nil {
+			desc = fmt.Sprintf("%s %d %d:%d %s %s", fc.Mode, fc.Size, fc.Uid, fc.Gid, fc.ModTime.Format(layout), fc.HexDigest())
+			if prev == nil && i > 0 {
+				desc += " (added)"
+			} else if prev != nil {
+				if changes := fc.Diff(prev, fc.Attrs&prev.Attrs); len(changes) > 0 {
+					desc += " (" + strings.Join(changes, ", ") + ")"
+				}
+			}
+		} else if prev != nil {
+			desc += " (removed)"
+		}
+		if _, err := fmt.Fprintf(w, "%-3d %s %-12s %s\n", v.ID, created, label, desc); err != nil {
+			return err
+		}
+		prev = v.Record
+	}
+	return nil
+}
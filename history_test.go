@@ -0,0 +1,81 @@
+package fcheck
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type HistorySuite struct {
+	scratch
+}
+
+var _ = Suite(&HistorySuite{})
+
+func (s *HistorySuite) SetUpTest(c *C) {
+	s.setUp(c)
+}
+
+func (s *HistorySuite) TestHistory(c *C) {
+	h := NewHistory(s.dbName, 3)
+	generate := func(label string) {
+		s.generate(c, func(g *Generator) {
+			g.SetHistory(h)
+			g.SetLabel(label)
+		})
+	}
+	a := filepath.Join(s.root, "a")
+	s.write(c, "b", "b")
+	generate("first")
+	s.write(c, "a", "1")
+	generate("v1")
+	s.write(c, "a", "22")
+	generate("v2")
+	c.Assert(os.Remove(a), IsNil)
+	generate("gone")
+	//only 3 generations are kept, first is gone
+	gens, err := h.Generations()
+	c.Assert(err, IsNil)
+	c.Assert(gens, HasLen, 3)
+	var labels []string
+	for _, g := range gens {
+		labels = append(labels, g.Header.Label)
+	}
+	c.Assert(labels, DeepEquals, []string{"v1", "v2", "gone"})
+	c.Assert(gens[2].ID, Equals, 0)
+	c.Assert(gens[2].File, Equals, s.dbName)
+	g, err := h.Resolve("v1")
+	c.Assert(err, IsNil)
+	c.Assert(g.ID, Equals, 2)
+	g, err = h.Resolve("1")
+	c.Assert(err, IsNil)
+	c.Assert(g.Header.Label, Equals, "v2")
+	_, err = h.Resolve("first")
+	c.Assert(err, NotNil)
+	//checking against an older generation
+	var buf bytes.Buffer
+	cm := NewComparator(g.File, 2, false)
+	cm.console = &buf
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+	c.Assert(cm.Stop(), IsNil)
+	c.Assert(cm.removedFiles, DeepEquals, []string{a})
+	versions, err := h.FileHistory(a)
+	c.Assert(err, IsNil)
+	c.Assert(versions, HasLen, 3)
+	c.Assert(versions[0].Record.Size, Equals, int64(1))
+	c.Assert(versions[2].Record, IsNil)
+	buf.Reset()
+	c.Assert(WriteFileHistory(&buf, versions), IsNil)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	c.Assert(lines, HasLen, 3)
+	c.Assert(strings.HasPrefix(lines[0], "2   "), Equals, true)
+	c.Assert(strings.Contains(lines[0], " v1 "), Equals, true)
+	c.Assert(strings.Contains(lines[1], "(size, "), Equals, true)
+	c.Assert(strings.HasSuffix(lines[1], ", digest)"), Equals, true)
+	c.Assert(strings.HasSuffix(lines[2], "gone         absent (removed)"), Equals, true)
+}
@@ -0,0 +1,547 @@
+package fcheck
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Import formats understood by Importer
+const (
+	ImportSums  = "sums"  // sha512sum, sha256sum or md5sum output in either layout, dpkg md5sums files
+	ImportMtree = "mtree" // BSD mtree specification
+	ImportRPM   = "rpm"   // rpm -qa --dump output
+)
+
+//errNothingImported is returned by Importer.Commit when no records were read
+var errNothingImported = errors.New("no records to import")
+
+//bsdSumRe matches the BSD (--tag) layout of sha*sum and md5sum output
+var bsdSumRe = regexp.MustCompile(`^(MD5|SHA256|SHA512) \((.*)\) = ([0-9a-fA-F]+)$`)
+
+//mtreeTypes maps mtree types to the type bits of os.FileMode
+var mtreeTypes = map[string]os.FileMode{
+	"file":   0,
+	"dir":    os.ModeDir,
+	"link":   os.ModeSymlink,
+	"fifo":   os.ModeNamedPipe,
+	"socket": os.ModeSocket,
+	"char":   os.ModeDevice | os.ModeCharDevice,
+	"block":  os.ModeDevice,
+}
+
+//unixTypes maps the S_IFMT bits of a unix mode to mtree types
+var unixTypes = map[uint32]string{
+	0100000: "file",
+	0040000: "dir",
+	0120000: "link",
+	0010000: "fifo",
+	0140000: "socket",
+	0020000: "char",
+	0060000: "block",
+}
+
+//Importer builds DB records from the baselines of other tools, such as vendor supplied checksums, so that
+//Comparator verifies files against them; the records only have the attributes their source knows
+type Importer struct {
+	dbfile  string
+	root    string // what relative paths are relative to
+	merge   bool
+	records map[string]*FileCheckInfo
+	owners  map[string]uint32 // user and group names looked up, prefixed with u: and g:
+	history *History
+	label   string
+}
+
+//NewImporter returns new Importer writing the DB in dbfname
+func NewImporter(dbfname string) *Importer {
+	return &Importer{dbfile: dbfname, root: "/", records: make(map[string]*FileCheckInfo), owners: make(map[string]uint32)}
+}
+
+//SetRoot sets what relative paths in the sources are relative to, / by default (as in dpkg md5sums and mtree specs)
+func (im *Importer) SetRoot(root string) {
+	im.root = filepath.Clean(root)
+}
+
+//SetMerge makes Commit add the records to the current DB instead of replacing it, records replace entries of the same path
+func (im *Importer) SetMerge(merge bool) {
+	im.merge = merge
+}
+
+//SetHistory makes the Importer keep the DB it replaces as a previous generation in h
+func (im *Importer) SetHistory(h *History) {
+	im.history = h
+}
+
+//SetLabel sets the label recorded in the header of the DB written
+func (im *Importer) SetLabel(label string) {
+	im.label = label
+}
+
+//ReadFile reads the records of format from fname, - for stdin, and returns how many were read
+func (im *Importer) ReadFile(format, fname string) (int, error) {
+	if fname == "-" {
+		return im.Read(format, os.Stdin, "stdin")
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return im.Read(format, f, fname)
+}
+
+//Read reads the records of format from r (name is used in errors) and returns how many were read
+//a record read later replaces an earlier one of the same path
+func (im *Importer) Read(format string, r io.Reader, name string) (int, error) {
+	var parse func(line string) (*FileCheckInfo, error)
+	switch format {
+	case ImportSums:
+		parse = im.parseSum
+	case ImportMtree:
+		parse = newMtreeParser(im).parse
+	case ImportRPM:
+		parse = im.parseRPM
+	default:
+		return 0, fmt.Errorf("unknown import format %q", format)
+	}
+	br := bufio.NewReader(r)
+	n := 0
+	for lineno := 1; ; lineno++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		if line != "" {
+			fc, perr := parse(strings.TrimRight(line, "\r\n"))
+			if perr != nil {
+				return n, fmt.Errorf("%s:%d: %s", name, lineno, perr)
+			}
+			if fc != nil {
+				im.records[fc.Path] = fc
+				n++
+			}
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+	}
+}
+
+//Commit writes the records read into the DB, paths below the merged /usr links of the root (/bin to usr/bin and so on,
+//as listed by dpkg) are recorded as the paths below /usr the walk finds the files at
+func (im *Importer) Commit() error {
+	if len(im.records) == 0 {
+		return errNothingImported
+	}
+	records := im.mergedUsr()
+	keep := im.merge
+	if _, err := os.Stat(im.dbfile); os.IsNotExist(err) {
+		//nothing to merge with
+		keep = false
+	}
+	var roots []string
+	for path, fc := range records {
+		if !fc.Mode.IsDir() {
+			path = filepath.Dir(path)
+		}
+		roots = append(roots, path)
+	}
+	return rewriteDB(im.dbfile, keep, records, NewPathPrefixes(roots...).Outermost(), im.label, im.history)
+}
+
+//mergedUsr returns the records with the paths below the merged /usr links of the root rewritten to the link targets
+//a record read with the target path wins over one read through the link
+func (im *Importer) mergedUsr() map[string]*FileCheckInfo {
+	var aliases []PathRewrite
+	for _, dir := range mergedUsrDirs(im.root) {
+		aliases = append(aliases, PathRewrite{filepath.Join(im.root, dir), filepath.Join(im.root, "usr", dir)})
+	}
+	if len(aliases) == 0 {
+		return im.records
+	}
+	records := make(map[string]*FileCheckInfo, len(im.records))
+	aliased := make(map[string]*FileCheckInfo)
+	for path, fc := range im.records {
+		real := path
+		for _, rw := range aliases {
+			//the link itself stays
+			if path != rw.From {
+				if real = rw.Apply(path); real != path {
+					break
+				}
+			}
+		}
+		if real == path {
+			records[path] = fc
+			continue
+		}
+		rec := *fc
+		rec.Path = real
+		aliased[real] = &rec
+	}
+	for path, fc := range aliased {
+		if _, ok := records[path]; !ok {
+			records[path] = fc
+		}
+	}
+	return records
+}
+
+//path returns path of a source as an absolute path
+func (im *Importer) path(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(im.root, path)
+}
+
+//owner returns the id of the user (kind u) or group (kind g) name, false if it is not known here
+func (im *Importer) owner(kind, name string) (uint32, bool) {
+	if id, ok := im.owners[kind+":"+name]; ok {
+		return id, true
+	}
+	var sid string
+	if kind == "u" {
+		if u, err := user.Lookup(name); err == nil {
+			sid = u.Uid
+		}
+	} else if g, err := user.LookupGroup(name); err == nil {
+		sid = g.Gid
+	}
+	id, err := strconv.ParseUint(sid, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	im.owners[kind+":"+name] = uint32(id)
+	return uint32(id), true
+}
+
+//setDigest sets the checksum given in hex on fc along with the attribute telling which one it is
+func setDigest(fc *FileCheckInfo, s string) error {
+	digest, err := hex.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("invalid checksum %q", s)
+	}
+	var attr Attr
+	switch len(digest) {
+	case 16:
+		attr = AttrMD5
+	case 32:
+		attr = AttrSHA256
+	case 64:
+		attr = AttrSHA512
+	default:
+		return fmt.Errorf("unsupported checksum %q, only md5, sha256 and sha512 are", s)
+	}
+	fc.Attrs = fc.Attrs&^AttrContent | attr
+	fc.Digest = nil
+	//empty files are recorded without a checksum
+	if h := attr.newHash(); string(h.Sum(nil)) != string(digest) {
+		fc.Digest = digest
+	}
+	return nil
+}
+
+//parseSum parses a line of sha*sum or md5sum output
+func (im *Importer) parseSum(line string) (*FileCheckInfo, error) {
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+	var sum, name string
+	if m := bsdSumRe.FindStringSubmatch(line); m != nil {
+		name, sum = m[2], m[3]
+	} else {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 || i+2 > len(line) || line[i+1] != ' ' && line[i+1] != '*' {
+			return nil, fmt.Errorf("expected a checksum and a file name")
+		}
+		sum, name = line[:i], line[i+2:]
+	}
+	if escaped {
+		name = strings.NewReplacer("\\\\", "\\", "\\n", "\n", "\\r", "\r").Replace(name)
+	}
+	fc := &FileCheckInfo{Path: im.path(name)}
+	return fc, setDigest(fc, sum)
+}
+
+//parseRPM parses a line of rpm --dump output: path size mtime digest mode owner group isconfig isdoc rdev symlink
+func (im *Importer) parseRPM(line string) (*FileCheckInfo, error) {
+	fields := strings.Fields(line)
+	n := len(fields)
+	if n == 0 {
+		return nil, nil
+	}
+	if n < 11 {
+		return nil, fmt.Errorf("expected 11 fields")
+	}
+	fields = append([]string{strings.Join(fields[:n-10], " ")}, fields[n-10:]...)
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid size %q", fields[1])
+	}
+	mtime, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mtime %q", fields[2])
+	}
+	mode, err := strconv.ParseUint(fields[4], 8, 32)
+	typ, ok := unixTypes[uint32(mode)&0170000]
+	if err != nil || !ok {
+		return nil, fmt.Errorf("invalid mode %q", fields[4])
+	}
+	fc := &FileCheckInfo{
+		Path:    im.path(fields[0]),
+		Size:    size,
+		Mode:    fileMode(typ, uint32(mode)),
+		ModTime: time.Unix(mtime, 0),
+		Attrs:   AttrPerm | AttrSize | AttrMtime,
+	}
+	if fc.Uid, ok = im.owner("u", fields[5]); ok {
+		fc.Attrs |= AttrUser
+	}
+	if fc.Gid, ok = im.owner("g", fields[6]); ok {
+		fc.Attrs |= AttrGroup
+	}
+	//directories and links have a checksum of zeros
+	if fc.Mode.IsRegular() && strings.Trim(fields[3], "0") != "" {
+		if err := setDigest(fc, fields[3]); err != nil {
+			return nil, err
+		}
+	}
+	return fc, nil
+}
+
+//fileMode returns the os.FileMode of mtree type typ with the unix permission bits of perm (including setuid, setgid and sticky)
+func fileMode(typ string, perm uint32) os.FileMode {
+	mode := mtreeTypes[typ] | os.FileMode(perm&0777)
+	if perm&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if perm&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if perm&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+//mtreeParser keeps the state of an mtree specification across its lines
+type mtreeParser struct {
+	im       *Importer
+	defaults map[string]string // set by /set
+	cwd      string            // directory names without a slash are relative to
+	cont     string            // line continued on the next one
+}
+
+func newMtreeParser(im *Importer) *mtreeParser {
+	return &mtreeParser{im: im, defaults: make(map[string]string), cwd: "."}
+}
+
+//parse parses a line of an mtree specification, both full paths and the directory hierarchy of mtree -c
+func (p *mtreeParser) parse(line string) (*FileCheckInfo, error) {
+	if strings.HasSuffix(line, "\\") && !strings.HasSuffix(line, "\\\\") {
+		p.cont += strings.TrimSuffix(line, "\\") + " "
+		return nil, nil
+	}
+	line, p.cont = p.cont+line, ""
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return nil, nil
+	}
+	switch fields[0] {
+	case "/set":
+		for k, v := range mtreeKeywords(fields[1:]) {
+			p.defaults[k] = v
+		}
+		return nil, nil
+	case "/unset":
+		for _, k := range fields[1:] {
+			if k == "all" {
+				p.defaults = make(map[string]string)
+			}
+			delete(p.defaults, k)
+		}
+		return nil, nil
+	case "..":
+		p.cwd = filepath.Dir(p.cwd)
+		return nil, nil
+	}
+	name, err := mtreeUnescape(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	kw := mtreeKeywords(fields[1:])
+	for k, v := range p.defaults {
+		if _, ok := kw[k]; !ok {
+			kw[k] = v
+		}
+	}
+	full := strings.Contains(name, "/")
+	if !full {
+		name = filepath.Join(p.cwd, name)
+	}
+	typ := kw["type"]
+	if typ == "" {
+		typ = "file"
+	}
+	if _, ok := mtreeTypes[typ]; !ok {
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+	if typ == "dir" && !full {
+		p.cwd = name
+	}
+	fc := &FileCheckInfo{Path: p.im.path(name), Mode: mtreeTypes[typ]}
+	return fc, p.apply(fc, typ, kw)
+}
+
+//apply sets the attributes given by keywords kw on fc
+func (p *mtreeParser) apply(fc *FileCheckInfo, typ string, kw map[string]string) error {
+	num := func(k string, bits int) (uint64, bool, error) {
+		v, ok := kw[k]
+		if !ok {
+			return 0, false, nil
+		}
+		n, err := strconv.ParseUint(v, 10, bits)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid %s %q", k, v)
+		}
+		return n, true, nil
+	}
+	if v, ok := kw["mode"]; ok {
+		perm, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid mode %q, only octal modes are supported", v)
+		}
+		fc.Mode = fileMode(typ, uint32(perm))
+		fc.Attrs |= AttrPerm
+	}
+	for _, v := range []struct {
+		key   string
+		attr  Attr
+		owner string
+		dst   *uint32
+	}{{"uid", AttrUser, "uname", &fc.Uid}, {"gid", AttrGroup, "gname", &fc.Gid}} {
+		id, ok, err := num(v.key, 32)
+		if err != nil {
+			return err
+		}
+		if name, named := kw[v.owner]; !ok && named {
+			var known uint32
+			if known, ok = p.im.owner(v.owner[:1], name); ok {
+				id = uint64(known)
+			}
+		}
+		if ok {
+			*v.dst = uint32(id)
+			fc.Attrs |= v.attr
+		}
+	}
+	for _, v := range []struct {
+		key  string
+		attr Attr
+		dst  *uint64
+	}{{"nlink", AttrLinks, &fc.Nlink}, {"inode", AttrInode, &fc.Inode}} {
+		n, ok, err := num(v.key, 64)
+		if err != nil {
+			return err
+		}
+		if ok {
+			*v.dst = n
+			fc.Attrs |= v.attr
+		}
+	}
+	size, ok, err := num("size", 63)
+	if err != nil {
+		return err
+	}
+	if ok {
+		fc.Size = int64(size)
+		fc.Attrs |= AttrSize
+	}
+	if v, ok := kw["time"]; ok {
+		if fc.ModTime, err = parseMtreeTime(v); err != nil {
+			return err
+		}
+		fc.Attrs |= AttrMtime
+	}
+	//the strongest checksum given
+	for _, k := range []string{"md5", "md5digest", "sha256", "sha256digest", "sha512", "sha512digest"} {
+		if v, ok := kw[k]; ok && fc.Mode.IsRegular() {
+			if err := setDigest(fc, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//mtreeKeywords returns the keyword=value pairs of fields, keywords without a value map to ""
+func mtreeKeywords(fields []string) map[string]string {
+	kw := make(map[string]string, len(fields))
+	for _, f := range fields {
+		if i := strings.IndexByte(f, '='); i >= 0 {
+			kw[f[:i]] = f[i+1:]
+		} else {
+			kw[f] = ""
+		}
+	}
+	return kw
+}
+
+//mtreeUnescape undoes the \ooo escapes of mtree file names, the reverse of mtreeEscape
+func mtreeUnescape(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+4 > len(s) {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		c, err := strconv.ParseUint(s[i+1:i+4], 8, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		b.WriteByte(byte(c))
+		i += 3
+	}
+	return b.String(), nil
+}
+
+//parseMtreeTime parses the seconds.nanoseconds of an mtree time keyword
+func parseMtreeTime(v string) (time.Time, error) {
+	secs, frac := v, ""
+	if i := strings.IndexByte(v, '.'); i >= 0 {
+		secs, frac = v[:i], v[i+1:]
+	}
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	var nsec int64
+	if err == nil && frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		nsec, err = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", v)
+	}
+	return time.Unix(sec, nsec), nil
+}
@@ -0,0 +1,162 @@
+package fcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ImportSuite struct{}
+
+var _ = Suite(&ImportSuite{})
+
+const (
+	md5Abc    = "900150983cd24fb0d6963f7d28e17f72"
+	sha256Abc = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+)
+
+func (s *ImportSuite) TestSums(c *C) {
+	im := NewImporter("unused.db")
+	im.SetRoot("/srv")
+	n, err := im.Read(ImportSums, strings.NewReader(strings.Join([]string{
+		md5Abc + "  usr/bin/abc",
+		sha256Abc + " */etc/abc",
+		"SHA256 (/etc/tagged) = " + sha256Abc,
+		`\` + md5Abc + `  /odd\nname`,
+		"d41d8cd98f00b204e9800998ecf8427e  /empty",
+	}, "\n")), "sums")
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 5)
+	c.Assert(im.records["/srv/usr/bin/abc"].Attrs, Equals, AttrMD5)
+	c.Assert(fmt.Sprintf("%x", im.records["/srv/usr/bin/abc"].Digest), Equals, md5Abc)
+	c.Assert(im.records["/etc/abc"].Attrs, Equals, AttrSHA256)
+	c.Assert(im.records["/etc/tagged"].Attrs, Equals, AttrSHA256)
+	c.Assert(im.records["/odd\nname"], NotNil)
+	c.Assert(im.records["/empty"].Digest, IsNil)
+
+	_, err = im.Read(ImportSums, strings.NewReader("abcd  /short\n"), "bad")
+	c.Assert(err, ErrorMatches, `bad:1: unsupported checksum .*`)
+	_, err = im.Read(ImportSums, strings.NewReader("\n"+md5Abc+"/no/separator\n"), "bad")
+	c.Assert(err, ErrorMatches, `bad:2: expected a checksum and a file name`)
+}
+
+func (s *ImportSuite) TestMtree(c *C) {
+	im := NewImporter("unused.db")
+	n, err := im.Read(ImportMtree, strings.NewReader(`#mtree
+/set type=file uid=0 gid=0 mode=0644
+. type=dir mode=0755 nlink=3
+    usr type=dir
+        tool mode=04755 size=3 time=1600000000.5 \
+            sha256digest=`+sha256Abc+`
+    ..
+    odd\040name size=0
+./etc/passwd type=file uid=0 gid=0 mode=0644 size=3 time=1600000000.000000001 md5digest=`+md5Abc+`
+`), "spec")
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 5)
+	root := im.records["/"]
+	c.Assert(root.Mode, Equals, os.ModeDir|0755)
+	c.Assert(root.Attrs, Equals, AttrPerm|AttrUser|AttrGroup|AttrLinks)
+	c.Assert(root.Nlink, Equals, uint64(3))
+	c.Assert(im.records["/usr"].Mode, Equals, os.ModeDir|0644)
+	tool := im.records["/usr/tool"]
+	c.Assert(tool.Mode, Equals, os.ModeSetuid|0755)
+	c.Assert(tool.Attrs, Equals, AttrPerm|AttrUser|AttrGroup|AttrSize|AttrMtime|AttrSHA256)
+	c.Assert(tool.ModTime.Equal(time.Unix(1600000000, 500000000)), Equals, true)
+	c.Assert(im.records["/odd name"].Size, Equals, int64(0))
+	passwd := im.records["/etc/passwd"]
+	c.Assert(passwd.ModTime.Equal(time.Unix(1600000000, 1)), Equals, true)
+	c.Assert(passwd.Attrs&AttrContent, Equals, AttrMD5)
+}
+
+func (s *ImportSuite) TestRPM(c *C) {
+	im := NewImporter("unused.db")
+	n, err := im.Read(ImportRPM, strings.NewReader(strings.Join([]string{
+		"/usr/bin/abc 3 1600000000 " + sha256Abc + " 0100755 root root 0 0 0 X",
+		"/usr/share/doc/a b 0 1600000000 " + strings.Repeat("0", 64) + " 040755 root root 0 1 0 X",
+	}, "\n")), "dump")
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+	abc := im.records["/usr/bin/abc"]
+	c.Assert(abc.Mode, Equals, os.FileMode(0755))
+	c.Assert(abc.Attrs&(AttrPerm|AttrSize|AttrMtime|AttrSHA256), Equals, AttrPerm|AttrSize|AttrMtime|AttrSHA256)
+	c.Assert(abc.ModTime.Equal(time.Unix(1600000000, 0)), Equals, true)
+	doc := im.records["/usr/share/doc/a b"]
+	c.Assert(doc.Mode, Equals, os.ModeDir|0755)
+	c.Assert(doc.Digest, IsNil)
+
+	_, err = im.Read(ImportRPM, strings.NewReader("/x 1 2 3\n"), "dump")
+	c.Assert(err, ErrorMatches, `dump:1: expected 11 fields`)
+}
+
+func (s *ImportSuite) TestCheckAgainstImport(c *C) {
+	root := c.MkDir()
+	dbName := filepath.Join(c.MkDir(), "fcheck_test.db")
+	c.Assert(ioutil.WriteFile(filepath.Join(root, "abc"), []byte("abc"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(root, "other"), []byte("other"), 0644), IsNil)
+	im := NewImporter(dbName)
+	im.SetRoot(root)
+	_, err := im.Read(ImportSums, strings.NewReader(fmt.Sprintf("%s  abc\n%x  other\n", sha256Abc, sha256.Sum256([]byte("other")))), "sums")
+	c.Assert(err, IsNil)
+	c.Assert(im.Commit(), IsNil)
+
+	check := func() *Comparator {
+		var buf bytes.Buffer
+		cm := NewComparator(dbName, 2, false)
+		cm.console = &buf
+		c.Assert(cm.Start(), IsNil)
+		c.Assert(cm.StartWalking(context.Background(), []string{root}, NewMatcher()), IsNil)
+		c.Assert(cm.Stop(), IsNil)
+		return cm
+	}
+	cm := check()
+	//only the content is known
+	c.Assert(cm.changedFiles, HasLen, 0)
+	c.Assert(cm.newFiles, DeepEquals, []string{root})
+	c.Assert(ioutil.WriteFile(filepath.Join(root, "abc"), []byte("abd"), 0600), IsNil)
+	cm = check()
+	c.Assert(cm.changedFiles, DeepEquals, []string{filepath.Join(root, "abc")})
+
+	//merged records replace those of the same path and keep the rest
+	im = NewImporter(dbName)
+	im.SetMerge(true)
+	_, err = im.Read(ImportSums, strings.NewReader(fmt.Sprintf("%x  %s\n", sha256.Sum256([]byte("abd")), filepath.Join(root, "abc"))), "sums")
+	c.Assert(err, IsNil)
+	c.Assert(im.Commit(), IsNil)
+	cm = check()
+	c.Assert(cm.changedFiles, HasLen, 0)
+	c.Assert(cm.removedFiles, HasLen, 0)
+}
+
+func (s *ImportSuite) TestMergedUsr(c *C) {
+	root := c.MkDir()
+	dbName := filepath.Join(c.MkDir(), "fcheck_test.db")
+	c.Assert(os.MkdirAll(filepath.Join(root, "usr/bin"), 0755), IsNil)
+	c.Assert(os.Symlink("usr/bin", filepath.Join(root, "bin")), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(root, "usr/bin/ls"), []byte("abc"), 0755), IsNil)
+	im := NewImporter(dbName)
+	im.SetRoot(root)
+	//as dpkg lists files below the links
+	_, err := im.Read(ImportSums, strings.NewReader(sha256Abc+"  bin/ls\n"), "md5sums")
+	c.Assert(err, IsNil)
+	c.Assert(im.Commit(), IsNil)
+	var buf bytes.Buffer
+	cm := NewComparator(dbName, 2, false)
+	cm.console = &buf
+	c.Assert(cm.Start(), IsNil)
+	c.Assert(cm.StartWalking(context.Background(), []string{root}, NewMatcher()), IsNil)
+	c.Assert(cm.Stop(), IsNil)
+	c.Assert(cm.removedFiles, HasLen, 0)
+	c.Assert(cm.changedFiles, HasLen, 0)
+	sort.Strings(cm.newFiles)
+	c.Assert(cm.newFiles, DeepEquals, []string{root, filepath.Join(root, "bin"), filepath.Join(root, "usr"), filepath.Join(root, "usr/bin")})
+}
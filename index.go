@@ -27,8 +27,9 @@ func (pi *PathIndex) Get(k string) (int64, bool) {
 	var node *PEntry
 	var ok bool
 	node, ok = pi.GetNode(k)
-	if !ok {
-		return -1, ok
+	if !ok || node.Pos < 0 {
+		//not there or only a parent of entries, which DBs of imported records need not have
+		return -1, false
 	}
 	return node.Pos, ok
 }
@@ -79,6 +80,35 @@ func (pi *PathIndex) Size() int64 {
 	return pi.root.size()
 }
 
+//Walk calls f with the keys at or below path in the order filepath.Walk visits them, parents that were never set
+//are passed over, if f returns filepath.SkipDir the keys below the one it was called with are skipped
+func (pi *PathIndex) Walk(path string, f func(key string) error) error {
+	path = filepath.Clean(path)
+	node, ok := pi.GetNode(path)
+	if path == string(filepath.Separator) {
+		//the root itself is kept as a child with an empty name, what is below it as the other children
+		if ok && node.Pos >= 0 {
+			if err := f(path); err != nil {
+				if err == filepath.SkipDir {
+					return nil
+				}
+				return err
+			}
+		}
+		node, ok = &PEntry{Pos: -1, Children: pi.root.Children}, true
+		if len(node.Children) > 0 && node.Children[0].Name == "" {
+			node.Children = node.Children[1:]
+		}
+	}
+	if !ok {
+		return nil
+	}
+	if err := node.walk(path, f); err != filepath.SkipDir {
+		return err
+	}
+	return nil
+}
+
 type nodeStepF func(node *PEntry)
 
 //PEntry represents actual path index entry
@@ -156,6 +186,22 @@ func (pe *PEntry) size() int64 {
 	return n
 }
 
+func (pe *PEntry) walk(path string, f func(key string) error) error {
+	if pe.Pos >= 0 {
+		if err := f(path); err != nil {
+			return err
+		}
+	}
+	for _, v := range pe.Children {
+		if err := v.walk(filepath.Join(path, v.Name), f); err == filepath.SkipDir {
+			continue
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 //Traverse will travers the PEntry tree and call f on each entry
 func (pe *PEntry) Traverse(f nodeStepF) {
 	f(pe)
@@ -163,3 +209,35 @@ func (pe *PEntry) Traverse(f nodeStepF) {
 		v.Traverse(f)
 	}
 }
+
+//DigestIndex is a reverse lookup from file digests to the paths that have them
+type DigestIndex struct {
+	paths map[string][]string
+}
+
+//NewDigestIndex returns new instance of DigestIndex
+func NewDigestIndex() *DigestIndex {
+	return &DigestIndex{make(map[string][]string)}
+}
+
+//Add records the digest of fc, entries without digest are ignored
+func (di *DigestIndex) Add(fc *FileCheckInfo) {
+	if len(fc.Digest) == 0 {
+		return
+	}
+	k := string(fc.Digest)
+	di.paths[k] = append(di.paths[k], fc.Path)
+}
+
+//Lookup returns the paths of entries with digest d
+func (di *DigestIndex) Lookup(d []byte) []string {
+	if len(d) == 0 {
+		return nil
+	}
+	return di.paths[string(d)]
+}
+
+//Size returns the number of distinct digests in DigestIndex
+func (di *DigestIndex) Size() int {
+	return len(di.paths)
+}
@@ -2,6 +2,8 @@ package fcheck
 
 import (
 	"bytes"
+	"path/filepath"
+
 	. "gopkg.in/check.v1"
 )
 
@@ -76,3 +78,39 @@ func (s *IndexSuite) TestIndexDots(c *C) {
 	c.Assert(getok, Equals, true)
 	c.Assert(v, Equals, int64(33))
 }
+
+func (s *IndexSuite) TestDigestIndex(c *C) {
+	di := NewDigestIndex()
+	di.Add(&FileCheckInfo{Path: "/foo", Digest: []byte("one")})
+	di.Add(&FileCheckInfo{Path: "/bar", Digest: []byte("one")})
+	di.Add(&FileCheckInfo{Path: "/baz", Digest: []byte("two")})
+	di.Add(&FileCheckInfo{Path: "/empty"})
+	c.Assert(di.Size(), Equals, 2)
+	c.Assert(di.Lookup([]byte("one")), DeepEquals, []string{"/foo", "/bar"})
+	c.Assert(di.Lookup([]byte("two")), DeepEquals, []string{"/baz"})
+	c.Assert(di.Lookup([]byte("three")), HasLen, 0)
+	c.Assert(di.Lookup(nil), HasLen, 0)
+}
+
+func (s *IndexSuite) TestWalk(c *C) {
+	pi := NewPathIndex()
+	for i, p := range []string{"/", "/foo.d", "/foo/a", "/foo", "/bar/b/c", "/foo/a/b"} {
+		pi.Set(p, int64(i))
+	}
+	var keys []string
+	c.Assert(pi.Walk("/", func(k string) error {
+		keys = append(keys, k)
+		return nil
+	}), IsNil)
+	//in the order filepath.Walk would visit them, /bar and /bar/b were never set
+	c.Assert(keys, DeepEquals, []string{"/", "/bar/b/c", "/foo", "/foo/a", "/foo/a/b", "/foo.d"})
+	keys = nil
+	c.Assert(pi.Walk("/foo", func(k string) error {
+		keys = append(keys, k)
+		if k == "/foo/a" {
+			return filepath.SkipDir
+		}
+		return nil
+	}), IsNil)
+	c.Assert(keys, DeepEquals, []string{"/foo", "/foo/a"})
+}
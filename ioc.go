@@ -0,0 +1,229 @@
+package fcheck
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//iocHashes are the checksums hash lists may use, they are told apart by their length
+var iocHashes = map[int]func() hash.Hash{
+	md5.Size:    md5.New,
+	sha1.Size:   sha1.New,
+	sha256.Size: sha256.New,
+	sha512.Size: sha512.New,
+}
+
+//HashList holds known-bad (indicator of compromise) checksums, md5, sha1, sha256 or sha512, with their labels
+type HashList struct {
+	labels map[string]string // digest -> label
+	sizes  []int             // digest lengths in the list, the longest first
+}
+
+//NewHashList returns new empty HashList
+func NewHashList() *HashList {
+	return &HashList{labels: make(map[string]string)}
+}
+
+//Len returns the number of checksums in the list
+func (h *HashList) Len() int {
+	return len(h.labels)
+}
+
+//Add adds digest with label to the list, digest has to be one of the supported checksums
+func (h *HashList) Add(digest []byte, label string) error {
+	if iocHashes[len(digest)] == nil {
+		return fmt.Errorf("unsupported checksum %x, only md5, sha1, sha256 and sha512 are", digest)
+	}
+	h.labels[string(digest)] = label
+	for _, size := range h.sizes {
+		if size == len(digest) {
+			return nil
+		}
+	}
+	h.sizes = append(h.sizes, len(digest))
+	sort.Sort(sort.Reverse(sort.IntSlice(h.sizes)))
+	return nil
+}
+
+//Load adds the checksums in the file fname, labelled with the file name unless the lines have labels
+func (h *HashList) Load(fname string) (int, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return h.Read(f, filepath.Base(fname))
+}
+
+//Read adds the checksums read from r and returns how many were read, name labels the lines without one
+//lines are either a checksum followed by an optional label (as sha256sum writes them)
+//or comma separated with the checksum in any column and the other columns making up the label,
+//a first line of CSV without a checksum is taken as its header, blank lines and those starting with # are skipped
+func (h *HashList) Read(r io.Reader, name string) (int, error) {
+	sc := bufio.NewScanner(r)
+	n, data := 0, false
+	for lineno := 1; sc.Scan(); lineno++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var fields []string
+		csvLine := strings.Contains(line, ",")
+		if csvLine {
+			var err error
+			if fields, err = csv.NewReader(strings.NewReader(line)).Read(); err != nil {
+				return n, fmt.Errorf("%s:%d: %s", name, lineno, err)
+			}
+		} else {
+			fields = strings.Fields(line)
+		}
+		digest, label := parseIOCFields(fields)
+		if digest == nil && csvLine && !data {
+			//header
+			data = true
+			continue
+		}
+		data = true
+		if digest == nil {
+			return n, fmt.Errorf("%s:%d: no md5, sha1, sha256 or sha512 checksum", name, lineno)
+		}
+		if label == "" {
+			label = name
+		}
+		if err := h.Add(digest, label); err != nil {
+			return n, fmt.Errorf("%s:%d: %s", name, lineno, err)
+		}
+		n++
+	}
+	return n, sc.Err()
+}
+
+//parseIOCFields returns the first field that is a supported checksum and the other fields as label
+func parseIOCFields(fields []string) ([]byte, string) {
+	var digest []byte
+	var label []string
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		if digest == nil {
+			if d, err := hex.DecodeString(f); err == nil && iocHashes[len(d)] != nil {
+				digest = d
+				continue
+			}
+		}
+		if f != "" {
+			label = append(label, f)
+		}
+	}
+	return digest, strings.Join(label, " ")
+}
+
+//Match returns the label of the checksum the content of fc has, if it has one on the list
+//the Digest of fc is used as it is, the other checksums the list has are calculated reading the file unless read is false
+func (h *HashList) Match(fc *FileCheckInfo, read bool) (string, bool, error) {
+	if h == nil || !fc.Mode.IsRegular() {
+		return "", false, nil
+	}
+	if label, ok := h.labels[string(fc.Digest)]; ok && len(fc.Digest) > 0 {
+		return label, true, nil
+	}
+	if !read {
+		return "", false, nil
+	}
+	var hashes []hash.Hash
+	var writers []io.Writer
+	for _, size := range h.sizes {
+		if size != len(fc.Digest) {
+			hs := iocHashes[size]()
+			hashes = append(hashes, hs)
+			writers = append(writers, hs)
+		}
+	}
+	if len(hashes) == 0 {
+		return "", false, nil
+	}
+	f, err := os.Open(fc.Path)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(io.MultiWriter(writers...), f); err != nil {
+		return "", false, err
+	}
+	for _, hs := range hashes {
+		if label, ok := h.labels[string(hs.Sum(nil))]; ok {
+			return label, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+//HashLookup is a DB only walker like Printer listing the entries with the given checksums (flag lookup-hash)
+//the DB only has the checksums it was generated with (sha512 unless imported), those are the ones that can be found
+type HashLookup struct {
+	FileInfoReader
+	digests [][]byte
+	console io.Writer
+	found   int
+}
+
+//NewHashLookup returns new HashLookup for the checksums in hex looking them up in the DB in dbfname
+func NewHashLookup(dbfname string, hexDigests ...string) (*HashLookup, error) {
+	hl := &HashLookup{FileInfoReader: NewDBReader(dbfname), console: os.Stdout}
+	for _, v := range hexDigests {
+		d, err := hex.DecodeString(strings.TrimSpace(v))
+		if err != nil || len(d) == 0 {
+			return nil, fmt.Errorf("invalid checksum %q", v)
+		}
+		hl.digests = append(hl.digests, d)
+	}
+	return hl, nil
+}
+
+//StartWalking indexes the entries at or below roots by checksum and prints those with the checksums asked for
+func (hl *HashLookup) StartWalking(ctx context.Context, roots []string, exclude *Matcher) error {
+	di := NewDigestIndex()
+	walk := NewPathPrefixes(roots...).Outermost()
+	for _, root := range walk {
+		err := hl.Map(root, func(fc *FileCheckInfo) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if walk.Longest(fc.Path) != root || exclude.dbExcluded(fc.Path, fc.Mode) {
+				//path is excluded
+				return nil
+			}
+			di.Add(fc)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, d := range hl.digests {
+		for _, path := range di.Lookup(d) {
+			hl.found++
+			if _, err := fmt.Fprintf(hl.console, "%x  %s\n", d, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//Found returns the number of entries found
+func (hl *HashLookup) Found() int {
+	return hl.found
+}
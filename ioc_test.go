@@ -0,0 +1,86 @@
+package fcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type IOCSuite struct {
+	scratch
+}
+
+var _ = Suite(&IOCSuite{})
+
+func (s *IOCSuite) SetUpTest(c *C) {
+	s.setUp(c)
+	s.write(c, "clean", "clean")
+	s.writeMode(c, "dropper", "evil", 0755)
+}
+
+func (s *IOCSuite) list(c *C) *HashList {
+	h := NewHashList()
+	n, err := h.Read(strings.NewReader(fmt.Sprintf("# from intel\nsha1,family,first seen\n%x,dropper,2026-01-02\n", sha1.Sum([]byte("evil")))), "intel.csv")
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	return h
+}
+
+func (s *IOCSuite) TestRead(c *C) {
+	h := NewHashList()
+	n, err := h.Read(strings.NewReader(fmt.Sprintf("%x\n\n%X  labelled\n", md5.Sum([]byte("a")), md5.Sum([]byte("b")))), "plain.txt")
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+	sum := md5.Sum([]byte("a"))
+	c.Assert(h.labels[string(sum[:])], Equals, "plain.txt")
+	sum = md5.Sum([]byte("b"))
+	c.Assert(h.labels[string(sum[:])], Equals, "labelled")
+
+	_, err = h.Read(strings.NewReader("abcd\n"), "bad.txt")
+	c.Assert(err, ErrorMatches, `bad.txt:1: no md5, sha1, sha256 or sha512 checksum`)
+	_, err = h.Read(strings.NewReader(fmt.Sprintf("%x,x\nnot,a,checksum\n", sum)), "bad.csv")
+	c.Assert(err, ErrorMatches, `bad.csv:2: no md5, sha1, sha256 or sha512 checksum`)
+}
+
+func (s *IOCSuite) TestCheck(c *C) {
+	g := s.generate(c, func(g *Generator) {
+		g.SetHashList(s.list(c))
+	})
+	hits := g.IOCMatches()
+	c.Assert(hits, HasLen, 1)
+	c.Assert(hits[0].Path, Equals, filepath.Join(s.root, "dropper"))
+	c.Assert(hits[0].Indicator, Equals, "dropper 2026-01-02")
+
+	//unchanged files are flagged too
+	cm, buf := s.compare(c, func(cm *Comparator) {
+		cm.SetHashList(s.list(c))
+	})
+	c.Assert(cm.Counts()[KindIOC], Equals, 1)
+	c.Assert(cm.Counts()[KindChanged], Equals, 0)
+	c.Assert(strings.Contains(buf.String(), "Known bad files 1\n\ncritical "+filepath.Join(s.root, "dropper")+" [dropper 2026-01-02]"), Equals, true)
+}
+
+func (s *IOCSuite) TestLookup(c *C) {
+	s.generate(c)
+	fc := &FileCheckInfo{Path: filepath.Join(s.root, "dropper"), Size: 4}
+	c.Assert(fc.CalcDigest(), IsNil)
+
+	var buf bytes.Buffer
+	hl, err := NewHashLookup(s.dbName, fc.HexDigest(), strings.Repeat("0", 128))
+	c.Assert(err, IsNil)
+	hl.console = &buf
+	c.Assert(hl.Start(), IsNil)
+	c.Assert(hl.StartWalking(context.Background(), []string{s.root}, NewMatcher()), IsNil)
+	c.Assert(hl.Stop(), IsNil)
+	c.Assert(hl.Found(), Equals, 1)
+	c.Assert(buf.String(), Equals, fc.HexDigest()+"  "+fc.Path+"\n")
+
+	_, err = NewHashLookup(s.dbName, "xyz")
+	c.Assert(err, NotNil)
+}
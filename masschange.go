@@ -0,0 +1,143 @@
+package fcheck
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+//Content whose byte entropy rose by entropyJump to at least entropyHigh bits per byte looks encrypted
+const (
+	entropyHigh = 7.2
+	entropyJump = 1.0
+)
+
+//entropyJumped returns true if the content of fc turned random looking since old was recorded with AttrEntropy
+func entropyJumped(old, fc *FileCheckInfo) bool {
+	return old.Attrs&AttrEntropy != 0 && fc.Entropy >= entropyHigh && fc.Entropy-old.Entropy >= entropyJump
+}
+
+//MassChange watches the findings of a Comparator for the signature of ransomware and raises mass-change alerts:
+//for the outermost directories (but the deepest of those holding the same files) where at least a fraction of the files changed, were added or removed
+//and when at least entropyFiles changed files turned random looking (needs AttrEntropy recorded)
+type MassChange struct {
+	fraction     float64        // 0 disables the directory alerts
+	minFiles     int            // directories with fewer files are not considered
+	entropyFiles int            // 0 disables the entropy alert
+	total        map[string]int // directory -> regular files at or below it, in the DB or new
+	affected     map[string]int // directory -> those of them that are new, changed, removed, moved or copied
+	jumped       []string       // changed files whose entropy jumped
+}
+
+//NewMassChange returns new MassChange alerting for directories of at least minFiles files when fraction of them
+//changed and when entropyFiles files turned random looking, 0 disables either alert
+func NewMassChange(fraction float64, minFiles, entropyFiles int) *MassChange {
+	return &MassChange{
+		fraction:     fraction,
+		minFiles:     minFiles,
+		entropyFiles: entropyFiles,
+		total:        make(map[string]int),
+		affected:     make(map[string]int),
+	}
+}
+
+//count adds the regular file at path to the directories above it, to their total and/or affected files
+//directories are only counted for the directory alerts
+func (m *MassChange) count(path string, total, affected bool) {
+	if m.fraction == 0 {
+		return
+	}
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if total {
+			m.total[dir]++
+		}
+		if affected {
+			m.affected[dir]++
+		}
+		if filepath.Dir(dir) == dir {
+			return
+		}
+	}
+}
+
+//entry counts the DB entry fc, whether or not it is still there
+func (m *MassChange) entry(fc *FileCheckInfo) {
+	if fc.Mode.IsRegular() {
+		m.count(fc.Path, true, false)
+	}
+}
+
+//finding counts f, expected findings are left out
+func (m *MassChange) finding(f *Finding) {
+	if f.Expected != "" {
+		return
+	}
+	switch f.Kind {
+	case KindNew, KindCopied:
+		if f.New.Mode.IsRegular() {
+			m.count(f.Path, true, true)
+		}
+	case KindMoved:
+		if f.New.Mode.IsRegular() {
+			m.count(f.Path, true, true)
+			m.count(f.From, false, true)
+		}
+	case KindChanged:
+		if f.New.Mode.IsRegular() {
+			m.count(f.Path, false, true)
+		}
+		if hasChange(f.Changes, "entropy") {
+			m.jumped = append(m.jumped, f.Path)
+		}
+	case KindRemoved:
+		if f.Old.Mode.IsRegular() {
+			m.count(f.Path, false, true)
+		}
+	}
+}
+
+//alerts returns the mass-change findings for what was counted
+func (m *MassChange) alerts() []*Finding {
+	var dirs []string
+	for dir, n := range m.affected {
+		if total := m.total[dir]; m.fraction > 0 && total >= m.minFiles && float64(n) >= m.fraction*float64(total) {
+			dirs = append(dirs, dir)
+		}
+	}
+	//a directory holding nothing but a subdirectory is the same files, the subdirectory is more telling
+	same := make(StringSet)
+	for _, dir := range dirs {
+		if parent := filepath.Dir(dir); parent != dir && m.total[parent] == m.total[dir] && m.affected[parent] == m.affected[dir] {
+			same.Add(parent)
+		}
+	}
+	//a directory sorts before anything below it
+	sort.Strings(dirs)
+	var alerts []*Finding
+	var reported PathPrefixes
+	for _, dir := range dirs {
+		if same.Has(dir) || reported.Match(dir) {
+			continue
+		}
+		reported = append(reported, dir)
+		n, total := m.affected[dir], m.total[dir]
+		alerts = append(alerts, &Finding{Kind: KindMassChange, Path: dir,
+			Summary: fmt.Sprintf("%d of %d files changed (%.0f%%)", n, total, float64(n)*100/float64(total))})
+	}
+	if m.entropyFiles > 0 && len(m.jumped) >= m.entropyFiles {
+		alerts = append(alerts, &Finding{Kind: KindMassChange, Path: commonDir(m.jumped),
+			Summary: fmt.Sprintf("%d files rewritten with random looking content", len(m.jumped))})
+	}
+	return alerts
+}
+
+//commonDir returns the deepest directory all of paths are below
+func commonDir(paths []string) string {
+	dir := filepath.Dir(paths[0])
+	for _, p := range paths[1:] {
+		for !HasPathPrefix(p, dir) {
+			dir = filepath.Dir(dir)
+		}
+	}
+	return dir
+}
@@ -0,0 +1,99 @@
+package fcheck
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type MassChangeSuite struct {
+	scratch
+}
+
+var _ = Suite(&MassChangeSuite{})
+
+func (s *MassChangeSuite) SetUpTest(c *C) {
+	s.setUp(c)
+}
+
+func (s *MassChangeSuite) TestEntropy(c *C) {
+	dir := c.MkDir()
+	random := make([]byte, 64*1024)
+	_, err := rand.Read(random)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "random"), random, 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "same"), bytes.Repeat([]byte("a"), 1024), 0644), IsNil)
+	fc := &FileCheckInfo{Path: filepath.Join(dir, "random"), Size: int64(len(random)), Attrs: DefaultAttrs | AttrEntropy}
+	c.Assert(fc.CalcDigest(), IsNil)
+	c.Assert(fc.Entropy > 7.9, Equals, true)
+	fc = &FileCheckInfo{Path: filepath.Join(dir, "same"), Size: 1024, Attrs: DefaultAttrs | AttrEntropy}
+	c.Assert(fc.CalcDigest(), IsNil)
+	c.Assert(fc.Entropy, Equals, 0.0)
+	c.Assert(fc.Digest, HasLen, 64)
+
+	fc.Entropy = 4.5
+	data, err := fc.MarshalBinary()
+	c.Assert(err, IsNil)
+	rfc := &FileCheckInfo{}
+	c.Assert(rfc.UnmarshalBinary(data), IsNil)
+	c.Assert(rfc.Entropy, Equals, 4.5)
+	//records without the attribute keep their size
+	fc.Attrs &^= AttrEntropy
+	short, err := fc.MarshalBinary()
+	c.Assert(err, IsNil)
+	c.Assert(len(data)-len(short), Equals, 8)
+	rfc = &FileCheckInfo{}
+	c.Assert(rfc.UnmarshalBinary(short), IsNil)
+	c.Assert(rfc.Entropy, Equals, 0.0)
+
+	p, err := ParsePolicy(strings.NewReader("/home DEFAULT+entropy\n"))
+	c.Assert(err, IsNil)
+	c.Assert(p.Records(AttrEntropy), Equals, true)
+	var nilp *Policy
+	c.Assert(nilp.Records(AttrEntropy), Equals, false)
+	c.Assert(nilp.Records(AttrSHA512), Equals, true)
+}
+
+func (s *MassChangeSuite) TestCheck(c *C) {
+	docs := filepath.Join(s.root, "home", "docs")
+	for i := 0; i < 10; i++ {
+		text := strings.Repeat(fmt.Sprintf("line %d of a plain text document\n", i), 200)
+		s.write(c, fmt.Sprintf("home/docs/doc%d.txt", i), text)
+		s.write(c, fmt.Sprintf("etc/conf%d", i), text)
+	}
+	p, err := ParsePolicy(strings.NewReader(s.root + " DEFAULT+entropy\n"))
+	c.Assert(err, IsNil)
+	s.generate(c, func(g *Generator) {
+		g.SetPolicy(p)
+	})
+
+	//most documents encrypted, a config file edited
+	for i := 0; i < 8; i++ {
+		random := make([]byte, 8000)
+		_, err := rand.Read(random)
+		c.Assert(err, IsNil)
+		s.write(c, fmt.Sprintf("home/docs/doc%d.txt", i), string(random))
+	}
+	s.write(c, "etc/conf0", "edited\n")
+
+	cm, buf := s.compare(c, func(cm *Comparator) {
+		cm.SetPolicy(p)
+		cm.SetMassChange(NewMassChange(0.5, 5, 3))
+	})
+	c.Assert(cm.Counts()[KindMassChange], Equals, 2)
+	c.Assert(cm.massChanges, DeepEquals, []string{docs, docs})
+	out := buf.String()
+	c.Assert(strings.Contains(out, "Mass changes 2\n"), Equals, true)
+	c.Assert(strings.Contains(out, "critical "+docs+" [8 of 10 files changed (80%)]\n"), Equals, true)
+	c.Assert(strings.Contains(out, "critical "+docs+" [8 files rewritten with random looking content]\n"), Equals, true)
+
+	//the entropy alert alone does not count directories
+	m := NewMassChange(0, 5, 3)
+	m.entry(&FileCheckInfo{Path: filepath.Join(docs, "doc0.txt")})
+	c.Assert(m.total, HasLen, 0)
+}
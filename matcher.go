@@ -0,0 +1,436 @@
+package fcheck
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//Matcher decides which paths are excluded from walking using an ordered list of rules
+//
+//Each rule is one line of the exclude file:
+//
+//	# comments and blank lines are ignored
+//	/var/cache                 literal path, excludes it and everything below it
+//	/home/*/.cache             shell glob, matched element by element
+//	/srv/**/node_modules       ** matches any number of path elements
+//	re:^/var/tmp/.*\.swp$      regular expression matched against the full path
+//	!/var/log/secure           re-include something excluded by an earlier rule
+//	type=socket,fifo /run      apply the rule only to entries of the listed types
+//	fstype=tmpfs               everything on filesystems of the listed types (globs like fuse.* work)
+//	fstype=ext4 /mnt           fstype can be combined with a pattern
+//
+//Literal, glob, regular expression and filesystem type rules also match everything below a matching path.
+//The last rule that matches a path decides whether it is excluded, paths no rule matches are included.
+//Every Matcher starts with a rule excluding pseudo, network and FUSE filesystems (proc, sysfs, nfs, fuse.* ...),
+//a later !fstype= rule re-includes them. It does not apply on the filesystem of a root walked that is on one of them,
+//the user asked for it. Filesystem types are read from /proc/self/mountinfo, so on other systems fstype rules
+//match nothing. Walkers reading only DBs ignore fstype rules, see dbExcluded.
+//A Matcher only holds the rules, what a walk needs to remember is in its walkScope, so it can serve several walks.
+type Matcher struct {
+	rules   []*matchRule
+	builtin int // number of leading rules added by NewMatcher
+	oneFS   bool
+}
+
+//walkScope is the state of one walk excluding with a Matcher
+type walkScope struct {
+	m        *Matcher
+	roots    PathPrefixes
+	devices  map[string]uint64 // device of each root, with SetOneFilesystem
+	ownMount map[string]string // mount point of the roots the builtin rule excludes, it does not apply on those mounts
+	otherFS  StringSet         // paths the walk found on another device than their root, with SetOneFilesystem
+}
+
+type matchRule struct {
+	include bool
+	types   []string
+	fstypes []string
+	literal string
+	glob    []string
+	re      *regexp.Regexp
+}
+
+//fileTypes maps type filter names to checks of os.FileMode
+var fileTypes = map[string]func(m os.FileMode) bool{
+	"file":    func(m os.FileMode) bool { return m.IsRegular() },
+	"dir":     func(m os.FileMode) bool { return m.IsDir() },
+	"symlink": func(m os.FileMode) bool { return m&os.ModeSymlink != 0 },
+	"socket":  func(m os.FileMode) bool { return m&os.ModeSocket != 0 },
+	"fifo":    func(m os.FileMode) bool { return m&os.ModeNamedPipe != 0 },
+	"device":  func(m os.FileMode) bool { return m&os.ModeDevice != 0 && m&os.ModeCharDevice == 0 },
+	"chardev": func(m os.FileMode) bool { return m&os.ModeCharDevice != 0 },
+}
+
+//NewMatcher returns new Matcher instance with only the rule excluding skipFSTypes
+func NewMatcher() *Matcher {
+	r, err := parseRule("fstype=" + strings.Join(skipFSTypes, ","))
+	if err != nil {
+		panic(err)
+	}
+	return &Matcher{rules: []*matchRule{r}, builtin: 1}
+}
+
+//SetOneFilesystem makes walks exclude everything that is not on the same device as the root it is below
+//(like find -xdev), a bind mount of the root's own filesystem is on the same device and still walked
+func (m *Matcher) SetOneFilesystem(on bool) {
+	m.oneFS = on
+}
+
+//newScope returns the walkScope of a walk covering paths, its roots are those walkers have to walk:
+//those lying below another root are dropped unless they are on another device with SetOneFilesystem on,
+//as walking the outer root does not enter them then
+func (m *Matcher) newScope(paths []string) *walkScope {
+	s := &walkScope{m: m, ownMount: make(map[string]string), otherFS: make(StringSet)}
+	if m == nil || !m.oneFS {
+		s.roots = NewPathPrefixes(paths...).Outermost()
+	} else {
+		all := NewPathPrefixes(paths...)
+		sort.Strings(all)
+		s.devices = make(map[string]uint64, len(all))
+		for _, v := range all {
+			dev, ok := lstatDevice(v)
+			if outer := s.roots.Longest(v); outer != "" && (!ok || s.devices[outer] == dev) {
+				continue
+			}
+			s.roots = append(s.roots, v)
+			if ok {
+				s.devices[v] = dev
+			}
+		}
+	}
+	for _, root := range s.roots {
+		if i := m.decide(root, os.ModeDir, 0, true); i >= 0 && i < m.builtin && !m.rules[i].include {
+			s.ownMount[root] = systemMounts().mountOf(root)
+		}
+	}
+	return s
+}
+
+//lstatDevice returns the device path is on
+func lstatDevice(path string) (uint64, bool) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, false
+	}
+	return deviceOf(info)
+}
+
+//ParseMatcher returns new Matcher with rules read line by line from r
+func ParseMatcher(r io.Reader) (*Matcher, error) {
+	m := NewMatcher()
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		if err := m.Add(scanner.Text()); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+	}
+	return m, scanner.Err()
+}
+
+//LoadMatcher returns new Matcher with rules read from file fname
+func LoadMatcher(fname string) (*Matcher, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := ParseMatcher(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fname, err)
+	}
+	return m, nil
+}
+
+//Add parses a single rule and appends it to the Matcher, blank lines and comments are ignored
+func (m *Matcher) Add(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	r, err := parseRule(line)
+	if err != nil {
+		return err
+	}
+	m.rules = append(m.rules, r)
+	return nil
+}
+
+//parseRule parses the rule syntax described at Matcher
+func parseRule(line string) (*matchRule, error) {
+	r := &matchRule{}
+	if strings.HasPrefix(line, "!") {
+		r.include = true
+		line = strings.TrimSpace(line[1:])
+	}
+	for strings.HasPrefix(line, "type=") || strings.HasPrefix(line, "fstype=") {
+		fields := strings.SplitN(line, " ", 2)
+		if strings.HasPrefix(fields[0], "fstype=") {
+			for _, t := range strings.Split(strings.TrimPrefix(fields[0], "fstype="), ",") {
+				if _, err := filepath.Match(t, ""); err != nil || t == "" {
+					return nil, fmt.Errorf("bad filesystem type %q", t)
+				}
+				r.fstypes = append(r.fstypes, t)
+			}
+		} else {
+			for _, t := range strings.Split(strings.TrimPrefix(fields[0], "type="), ",") {
+				if _, ok := fileTypes[t]; !ok {
+					return nil, fmt.Errorf("unknown type %q", t)
+				}
+				r.types = append(r.types, t)
+			}
+		}
+		if len(fields) < 2 {
+			if r.fstypes != nil && r.types == nil {
+				//all paths on those filesystems
+				return r, nil
+			}
+			return nil, fmt.Errorf("missing pattern after %s", fields[0])
+		}
+		line = strings.TrimSpace(fields[1])
+	}
+	switch {
+	case line == "":
+		return nil, fmt.Errorf("missing pattern")
+	case strings.HasPrefix(line, "re:"):
+		re, err := regexp.Compile(line[3:])
+		if err != nil {
+			return nil, err
+		}
+		r.re = re
+	case strings.ContainsAny(line, "*?["):
+		r.glob = globParts(line)
+		for _, v := range r.glob {
+			if _, err := filepath.Match(v, ""); err != nil {
+				return nil, fmt.Errorf("bad pattern %q: %s", line, err)
+			}
+		}
+	default:
+		r.literal = filepath.Clean(line)
+	}
+	return r, nil
+}
+
+//Len returns the number of rules, not counting the builtin one
+func (m *Matcher) Len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.rules) - m.builtin
+}
+
+//Excluded returns true if path with mode is excluded by the rules
+func (m *Matcher) Excluded(path string, mode os.FileMode) bool {
+	return m.excluded(path, mode, 0)
+}
+
+//excluded returns true if path with mode is excluded by the rules from the first-th on
+func (m *Matcher) excluded(path string, mode os.FileMode, first int) bool {
+	i := m.decide(path, mode, first, true)
+	return i >= 0 && !m.rules[i].include
+}
+
+//dbExcluded is Excluded for walkers reading only DBs, whose paths need not be on the filesystems mounted here
+//only the path rules apply, filesystem type rules (including the builtin one) and SetOneFilesystem are ignored
+func (m *Matcher) dbExcluded(path string, mode os.FileMode) bool {
+	i := m.decide(path, mode, 0, false)
+	return i >= 0 && !m.rules[i].include
+}
+
+//CanPrune returns true if dir is excluded together with everything below it, so walking can skip it
+func (m *Matcher) CanPrune(dir string) bool {
+	return m.canPrune(dir, 0)
+}
+
+//canPrune is CanPrune with the rules from the first-th on
+func (m *Matcher) canPrune(dir string, first int) bool {
+	i := m.decide(dir, os.ModeDir, first, true)
+	if i < 0 || m.rules[i].include || len(m.rules[i].types) > 0 {
+		return false
+	}
+	for _, r := range m.rules[i+1:] {
+		if r.include && r.mightMatchBelow(dir) {
+			return false
+		}
+	}
+	return true
+}
+
+//excluded is the exclude check shared by the filepath.WalkFunc implementations
+//it returns true if path is excluded, along with filepath.SkipDir if the whole directory can be skipped
+func (s *walkScope) excluded(path string, info os.FileInfo) (bool, error) {
+	if s.otherDevice(path, info) {
+		//remembered for the DB entries below it, mounts below it are not on the root's device either
+		s.otherFS.Add(filepath.Clean(path))
+		if info.IsDir() {
+			return true, filepath.SkipDir
+		}
+		return true, nil
+	}
+	//an entry that could not be stat'ed is of no known type, type filtered rules do not match it
+	mode := os.ModeIrregular
+	if info != nil {
+		mode = info.Mode()
+	}
+	first := s.first(path)
+	if !s.m.excluded(path, mode, first) {
+		return false, nil
+	}
+	if mode.IsDir() && s.m.canPrune(path, first) {
+		return true, filepath.SkipDir
+	}
+	return true, nil
+}
+
+//entryExcluded returns true if the DB entry path with mode was excluded from the walk,
+//by the rules or for being below a path found on another device
+func (s *walkScope) entryExcluded(path string, mode os.FileMode) bool {
+	if len(s.otherFS) > 0 {
+		for p := filepath.Clean(path); ; p = filepath.Dir(p) {
+			if s.otherFS.Has(p) {
+				return true
+			}
+			if filepath.Dir(p) == p {
+				break
+			}
+		}
+	}
+	return s.m.excluded(path, mode, s.first(path))
+}
+
+//otherDevice returns true if path with info is not on the device of its root and SetOneFilesystem is on
+func (s *walkScope) otherDevice(path string, info os.FileInfo) bool {
+	if info == nil || s.devices == nil {
+		return false
+	}
+	root, ok := s.devices[s.roots.Longest(path)]
+	dev, known := deviceOf(info)
+	return ok && known && dev != root
+}
+
+//first returns the index of the first rule applying to path, the builtin rule does not apply on the mount
+//of a root it would exclude
+func (s *walkScope) first(path string) int {
+	if mp, ok := s.ownMount[s.roots.Longest(path)]; ok && systemMounts().mountOf(path) == mp {
+		return s.m.builtin
+	}
+	return 0
+}
+
+//decide returns the index of the last rule from the first-th on matching path or -1,
+//rules with filesystem types are skipped unless fs
+func (m *Matcher) decide(path string, mode os.FileMode, first int, fs bool) int {
+	if m == nil || len(m.rules) == 0 {
+		return -1
+	}
+	path = filepath.Clean(path)
+	var parts []string
+	for i := len(m.rules) - 1; i >= first; i-- {
+		r := m.rules[i]
+		if !fs && len(r.fstypes) > 0 {
+			continue
+		}
+		if r.glob != nil && parts == nil {
+			parts = globParts(path)
+		}
+		if r.match(path, parts, mode) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *matchRule) match(path string, parts []string, mode os.FileMode) bool {
+	if len(r.types) > 0 {
+		ok := false
+		for _, t := range r.types {
+			ok = ok || fileTypes[t](mode)
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(r.fstypes) > 0 && !matchFSType(r.fstypes, systemMounts().typesOf(path)) {
+		return false
+	}
+	switch {
+	case r.fstypes != nil && r.literal == "" && r.glob == nil && r.re == nil:
+		return true
+	case r.re != nil:
+		for p := path; ; p = filepath.Dir(p) {
+			if r.re.MatchString(p) {
+				return true
+			}
+			if filepath.Dir(p) == p {
+				return false
+			}
+		}
+	case r.glob != nil:
+		return matchGlob(r.glob, parts)
+	}
+	return hasCleanPrefix(path, r.literal)
+}
+
+//mightMatchBelow returns true if the rule could match any path below dir
+func (r *matchRule) mightMatchBelow(dir string) bool {
+	if len(r.fstypes) > 0 && !matchFSType(r.fstypes, systemMounts().typesBelow(dir)) {
+		return false
+	}
+	switch {
+	case r.fstypes != nil && r.literal == "" && r.glob == nil && r.re == nil:
+		return true
+	case r.re != nil:
+		return true
+	case r.glob != nil:
+		//the elements before the first wildcard
+		var base []string
+		for _, v := range r.glob {
+			if strings.ContainsAny(v, "*?[") {
+				break
+			}
+			base = append(base, v)
+		}
+		b := strings.Join(base, string(filepath.Separator))
+		if b == "" {
+			return true
+		}
+		return hasCleanPrefix(b, dir) || hasCleanPrefix(dir, b)
+	}
+	return hasCleanPrefix(r.literal, dir) || hasCleanPrefix(dir, r.literal)
+}
+
+//globParts splits a cleaned path into elements, the leading empty element of absolute paths is kept
+func globParts(path string) []string {
+	path = filepath.Clean(path)
+	if path == string(filepath.Separator) {
+		return []string{""}
+	}
+	return strings.Split(path, string(filepath.Separator))
+}
+
+//matchGlob returns true if pattern matches parts or the elements of one of its parent directories
+func matchGlob(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchGlob(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if ok, _ := filepath.Match(pattern[0], parts[0]); !ok {
+		return false
+	}
+	return matchGlob(pattern[1:], parts[1:])
+}
@@ -0,0 +1,217 @@
+package fcheck
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type MatcherSuite struct{}
+
+var _ = Suite(&MatcherSuite{})
+
+const testRules = `
+# system directories
+/proc
+/var/log
+!/var/log/secure
+
+/home/*/.cache
+/srv/**/node_modules
+re:\.swp$
+type=socket,fifo /run
+type=dir /mnt
+`
+
+func (s *MatcherSuite) TestExcluded(c *C) {
+	m, err := ParseMatcher(strings.NewReader(testRules))
+	c.Assert(err, IsNil)
+	c.Assert(m.Len(), Equals, 8)
+	tests := []struct {
+		path     string
+		mode     os.FileMode
+		excluded bool
+	}{
+		{"/proc", os.ModeDir, true},
+		{"/proc/1/status", 0, true},
+		{"/processes", 0, false},
+		{"/var/log/syslog", 0, true},
+		{"/var/log/secure", 0, false},
+		{"/var/log/secure.1", 0, true},
+		{"/home/bob/.cache", os.ModeDir, true},
+		{"/home/bob/.cache/thumbnails/x.png", 0, true},
+		{"/home/bob/.config", os.ModeDir, false},
+		{"/home/.cache", os.ModeDir, false},
+		{"/srv/node_modules", os.ModeDir, true},
+		{"/srv/app/web/node_modules/left-pad/index.js", 0, true},
+		{"/srv/app/web/src/index.js", 0, false},
+		{"/etc/.passwd.swp", 0, true},
+		{"/etc/passwd", 0, false},
+		{"/run/dbus/system_bus_socket", os.ModeSocket, true},
+		{"/run/initctl", os.ModeNamedPipe, true},
+		{"/run/utmp", 0, false},
+		{"/run", os.ModeDir, false},
+		{"/mnt", os.ModeDir, true},
+		{"/mnt/usb/file", 0, false},
+	}
+	for _, t := range tests {
+		c.Check(m.Excluded(t.path, t.mode), Equals, t.excluded, Commentf("path %q", t.path))
+	}
+	//walk errors come without FileInfo, only rules without types apply
+	m.Add("type=file /srv")
+	scope := m.newScope([]string{"/"})
+	excluded, _ := scope.excluded("/srv/data", nil)
+	c.Assert(excluded, Equals, false)
+	excluded, _ = scope.excluded("/proc/1", nil)
+	c.Assert(excluded, Equals, true)
+}
+
+func (s *MatcherSuite) TestCanPrune(c *C) {
+	m, err := ParseMatcher(strings.NewReader(testRules))
+	c.Assert(err, IsNil)
+	c.Assert(m.CanPrune("/proc"), Equals, true)
+	c.Assert(m.CanPrune("/home/bob/.cache"), Equals, true)
+	//a later rule re-includes something below
+	c.Assert(m.CanPrune("/var/log"), Equals, false)
+	//type filtered rules only exclude the matching entries
+	c.Assert(m.CanPrune("/mnt"), Equals, false)
+	c.Assert(m.CanPrune("/etc"), Equals, false)
+	m.Add("!re:important")
+	c.Assert(m.CanPrune("/proc"), Equals, false)
+}
+
+func (s *MatcherSuite) TestEmpty(c *C) {
+	var nilm *Matcher
+	c.Assert(nilm.Excluded("/etc", os.ModeDir), Equals, false)
+	c.Assert(NewMatcher().Excluded("/etc", os.ModeDir), Equals, false)
+	m, err := ParseMatcher(strings.NewReader("\n   \n# only comments\n"))
+	c.Assert(err, IsNil)
+	c.Assert(m.Len(), Equals, 0)
+	c.Assert(m.Excluded("/", os.ModeDir), Equals, false)
+}
+
+func (s *MatcherSuite) TestBadRules(c *C) {
+	for _, line := range []string{"re:(", "type=bogus /x", "type=file", "!", "/a/[b"} {
+		c.Check(NewMatcher().Add(line), NotNil, Commentf("rule %q", line))
+	}
+	_, err := ParseMatcher(strings.NewReader("/ok\nre:(\n"))
+	c.Assert(err, ErrorMatches, "line 2: .*")
+}
+
+const testMountInfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+23 22 0:21 / /proc rw,nosuid shared:12 - proc proc rw
+24 22 0:22 / /sys rw,nosuid shared:2 - sysfs sysfs rw
+30 22 8:2 / /home rw,relatime shared:3 - ext4 /dev/sda2 rw
+31 30 0:40 / /home/bob/remote rw shared:4 - fuse.sshfs bob@host: rw
+32 22 0:41 / /mnt/nas\040share rw shared:5 - nfs4 nas:/share rw
+33 32 0:42 / /mnt/nas\040share/scratch rw shared:6 - tmpfs tmpfs rw
+34 22 8:1 /srv /var/www rw,relatime shared:1 - ext4 /dev/sda1 rw
+`
+
+//withMounts replaces the system mount table with the one parsed from info for the duration of f
+func withMounts(c *C, info string, f func()) {
+	systemMounts()
+	saved := mounts
+	defer func() { mounts = saved }()
+	t, err := parseMountInfo(strings.NewReader(info))
+	c.Assert(err, IsNil)
+	mounts = t
+	f()
+}
+
+func (s *MatcherSuite) TestFSTypes(c *C) {
+	withMounts(c, testMountInfo, func() {
+		c.Assert(systemMounts().mountOf("/mnt/nas share/x"), Equals, "/mnt/nas share")
+		m := NewMatcher()
+		tests := []struct {
+			path     string
+			excluded bool
+		}{
+			{"/", false},
+			{"/proc/1/status", true},
+			{"/sys", true},
+			{"/home/bob/.bashrc", false},
+			{"/home/bob/remote/file", true},
+			{"/mnt/nas share/file", true},
+			//on tmpfs, but mounted below the nfs share
+			{"/mnt/nas share/scratch/file", true},
+			{"/var/www/index.html", false},
+		}
+		for _, t := range tests {
+			c.Check(m.Excluded(t.path, 0), Equals, t.excluded, Commentf("path %q", t.path))
+			//DB paths are not looked up in the local mounts
+			c.Check(m.dbExcluded(t.path, 0), Equals, false, Commentf("path %q", t.path))
+		}
+		c.Assert(m.CanPrune("/proc"), Equals, true)
+		c.Assert(m.CanPrune("/home"), Equals, false)
+		c.Assert(m.Add("!fstype=nfs4"), IsNil)
+		c.Assert(m.Add("fstype=tmpfs /mnt"), IsNil)
+		c.Assert(m.Len(), Equals, 2)
+		c.Assert(m.Excluded("/mnt/nas share/file", 0), Equals, false)
+		c.Assert(m.Excluded("/mnt/nas share/scratch/file", 0), Equals, true)
+		c.Assert(m.Excluded("/home/bob/remote/file", 0), Equals, true)
+		//the nfs share is re-included, but not the tmpfs below it
+		c.Assert(m.CanPrune("/mnt/nas share"), Equals, false)
+		c.Assert(m.CanPrune("/mnt/nas share/scratch"), Equals, true)
+	})
+}
+
+func (s *MatcherSuite) TestOneFilesystem(c *C) {
+	root := c.MkDir()
+	a := filepath.Join(root, "a")
+	c.Assert(os.MkdirAll(filepath.Join(a, "b"), 0755), IsNil)
+	info, err := os.Lstat(a)
+	c.Assert(err, IsNil)
+	m := NewMatcher()
+	m.SetOneFilesystem(true)
+	//nested roots on the same device are walked with the outer one
+	scope := m.newScope([]string{a, root})
+	c.Assert(scope.roots, DeepEquals, PathPrefixes{root})
+	excluded, _ := scope.excluded(a, info)
+	c.Assert(excluded, Equals, false)
+	//as if a were on another device than the root
+	scope.devices[root]++
+	excluded, skip := scope.excluded(a, info)
+	c.Assert(excluded, Equals, true)
+	c.Assert(skip, Equals, filepath.SkipDir)
+	//the DB entries below it were not walked
+	c.Assert(scope.entryExcluded(filepath.Join(a, "b"), os.ModeDir), Equals, true)
+	c.Assert(scope.entryExcluded(filepath.Join(root, "c"), 0), Equals, false)
+	//the Matcher keeps nothing of a walk
+	c.Assert(m.newScope([]string{root}).entryExcluded(filepath.Join(a, "b"), os.ModeDir), Equals, false)
+	m.SetOneFilesystem(false)
+	scope = m.newScope([]string{a, root})
+	c.Assert(scope.roots, DeepEquals, PathPrefixes{root})
+	c.Assert(scope.devices, IsNil)
+}
+
+func (s *MatcherSuite) TestExplicitRoot(c *C) {
+	withMounts(c, testMountInfo, func() {
+		m := NewMatcher()
+		//the builtin rule does not apply on the filesystem of a root given on it
+		scope := m.newScope([]string{"/mnt/nas share"})
+		excluded, _ := scope.excluded("/mnt/nas share/file", nil)
+		c.Assert(excluded, Equals, false)
+		c.Assert(scope.entryExcluded("/mnt/nas share/file", 0), Equals, false)
+		//but on the mounts below it
+		excluded, _ = scope.excluded("/mnt/nas share/scratch/file", nil)
+		c.Assert(excluded, Equals, true)
+		//nor does it when walking from further up
+		excluded, _ = m.newScope([]string{"/"}).excluded("/mnt/nas share/file", nil)
+		c.Assert(excluded, Equals, true)
+		//rules of the user still do
+		c.Assert(m.Add("fstype=nfs4"), IsNil)
+		excluded, _ = m.newScope([]string{"/mnt/nas share"}).excluded("/mnt/nas share/file", nil)
+		c.Assert(excluded, Equals, true)
+	})
+}
+
+func (s *MatcherSuite) TestBadFSRules(c *C) {
+	for _, line := range []string{"fstype=", "fstype=ext4,", "fstype=[ext4", "type=file fstype=ext4"} {
+		c.Check(NewMatcher().Add(line), NotNil, Commentf("rule %q", line))
+	}
+	_, err := parseMountInfo(strings.NewReader("22 1 8:1 / / rw shared:1 ext4\n"))
+	c.Assert(err, NotNil)
+}
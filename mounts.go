@@ -0,0 +1,156 @@
+package fcheck
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//skipFSTypes are the filesystem types excluded by every Matcher unless re-included with a !fstype= rule
+//pseudo filesystems have nothing worth checking and network or FUSE filesystems can hang or change behind our back
+var skipFSTypes = []string{
+	"proc", "sysfs", "devpts", "cgroup", "cgroup2", "debugfs", "tracefs", "securityfs", "pstore", "bpf",
+	"configfs", "fusectl", "mqueue", "hugetlbfs", "binfmt_misc", "autofs", "rpc_pipefs", "nfsd",
+	"nfs", "nfs4", "cifs", "smb3", "ceph", "9p", "fuse", "fuse.*",
+}
+
+//mountTable maps mount points to filesystem types, the topmost mount wins where several share a mount point
+type mountTable map[string]string
+
+var (
+	mountsOnce sync.Once
+	mounts     mountTable // replaced by tests
+)
+
+//systemMounts returns the mounts of the running system read from /proc/self/mountinfo
+//the table is empty where that is not available (not Linux), so filesystem type rules never match
+func systemMounts() mountTable {
+	mountsOnce.Do(func() {
+		if mounts != nil {
+			return
+		}
+		mounts = mountTable{}
+		f, err := os.Open("/proc/self/mountinfo")
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		if t, err := parseMountInfo(f); err == nil {
+			mounts = t
+		}
+	})
+	return mounts
+}
+
+//parseMountInfo parses the format of /proc/<pid>/mountinfo, e.g.
+//36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func parseMountInfo(r io.Reader) (mountTable, error) {
+	t := mountTable{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 5 || sep < 0 || sep+1 >= len(fields) {
+			return nil, fmt.Errorf("bad mountinfo line %q", scanner.Text())
+		}
+		t[unescapeMount(fields[4])] = fields[sep+1]
+	}
+	return t, scanner.Err()
+}
+
+//unescapeMount decodes the octal escapes (\040 for space etc.) the kernel uses in mount points
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+//mountOf returns the mount point of the filesystem path lies on or "" if it is unknown
+func (t mountTable) mountOf(path string) string {
+	if len(t) == 0 {
+		return ""
+	}
+	path = absPath(path)
+	for {
+		if _, ok := t[path]; ok {
+			return path
+		}
+		next := filepath.Dir(path)
+		if next == path {
+			return ""
+		}
+		path = next
+	}
+}
+
+//typesOf returns the filesystem types of the mounts path lies on, the one holding path first and then
+//those of the mounts below which it was mounted
+func (t mountTable) typesOf(path string) []string {
+	var types []string
+	for mp := t.mountOf(path); mp != ""; {
+		types = append(types, t[mp])
+		if mp == filepath.Dir(mp) {
+			break
+		}
+		mp = t.mountOf(filepath.Dir(mp))
+	}
+	return types
+}
+
+//typesBelow returns the filesystem types of dir and of all mounts below it
+func (t mountTable) typesBelow(dir string) []string {
+	types := t.typesOf(dir)
+	dir = absPath(dir)
+	for mp, fstype := range t {
+		if hasCleanPrefix(mp, dir) {
+			types = append(types, fstype)
+		}
+	}
+	return types
+}
+
+//absPath returns path cleaned and made absolute as mount points are
+func absPath(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+//matchFSType returns true if any of types matches any of the patterns (shell globs, e.g. fuse.*)
+func matchFSType(patterns, types []string) bool {
+	for _, p := range patterns {
+		for _, t := range types {
+			if ok, _ := filepath.Match(p, t); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package fcheck
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//HasPathPrefix returns true if path equals prefix or lies below it
+//unlike strings.HasPrefix it respects path separators, so /var/lib is not a prefix of /var/library
+//both paths are cleaned first (trailing slashes, . and .. elements), an empty prefix matches nothing
+func HasPathPrefix(path, prefix string) bool {
+	if prefix == "" {
+		return false
+	}
+	return hasCleanPrefix(filepath.Clean(path), filepath.Clean(prefix))
+}
+
+func hasCleanPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	if len(path) == len(prefix) || prefix[len(prefix)-1] == filepath.Separator {
+		//exact match or prefix is the root
+		return true
+	}
+	return path[len(prefix)] == filepath.Separator
+}
+
+//PathPrefixes is a list of paths that match themselves and everything below them
+type PathPrefixes []string
+
+//NewPathPrefixes returns PathPrefixes made of cleaned prefixes, empty ones are dropped
+func NewPathPrefixes(prefixes ...string) PathPrefixes {
+	pp := make(PathPrefixes, 0, len(prefixes))
+	for _, v := range prefixes {
+		if v = strings.TrimSpace(v); v != "" {
+			pp = append(pp, filepath.Clean(v))
+		}
+	}
+	return pp
+}
+
+//Match returns true if path equals or lies below any of the prefixes
+func (pp PathPrefixes) Match(path string) bool {
+	if len(pp) == 0 {
+		return false
+	}
+	path = filepath.Clean(path)
+	for _, v := range pp {
+		if hasCleanPrefix(path, v) {
+			return true
+		}
+	}
+	return false
+}
+
+//Outermost returns the prefixes sorted and without duplicates and those lying below another prefix
+//walking the result covers every path once
+func (pp PathPrefixes) Outermost() PathPrefixes {
+	sorted := make(PathPrefixes, len(pp))
+	copy(sorted, pp)
+	sort.Strings(sorted)
+	var out PathPrefixes
+	for _, v := range sorted {
+		//a prefix sorts before anything below it
+		if !out.Match(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+//Longest returns the longest of the prefixes path equals or lies below or "" if none does
+func (pp PathPrefixes) Longest(path string) string {
+	path = filepath.Clean(path)
+	longest := ""
+	for _, v := range pp {
+		if len(v) > len(longest) && hasCleanPrefix(path, v) {
+			longest = v
+		}
+	}
+	return longest
+}
+
+//walkBefore returns true if filepath.Walk visits a before b, it visits directories before their entries
+//and the entries of a directory in lexical order
+func walkBefore(a, b string) bool {
+	pa, pb := globParts(a), globParts(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if pa[i] != pb[i] {
+			return pa[i] < pb[i]
+		}
+	}
+	return len(pa) < len(pb)
+}
@@ -0,0 +1,84 @@
+package fcheck
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type PathMatchSuite struct{}
+
+var _ = Suite(&PathMatchSuite{})
+
+func (s *PathMatchSuite) TestHasPathPrefix(c *C) {
+	tests := []struct {
+		path, prefix string
+		match        bool
+	}{
+		{"/var/lib", "/var/lib", true},
+		{"/var/lib/dpkg", "/var/lib", true},
+		{"/var/library", "/var/lib", false},
+		{"/var/lib", "/var/lib/", true},
+		{"/var/lib/", "/var/lib", true},
+		{"/var/lib/dpkg", "/var/lib//", true},
+		{"/var", "/var/lib", false},
+		{"/binaries", "/bin", false},
+		{"/bin/ls", "/bin", true},
+		{"/bin", "/", true},
+		{"/", "/", true},
+		{"/anything/at/all", "/", true},
+		{"/usr/bin/../lib/x", "/usr/lib", true},
+		{"/usr/lib/x", "/usr/bin/../lib", true},
+		{"/usr/bin/x", "/usr/bin/../lib", false},
+		{"/etc/./passwd", "/etc", true},
+		{"relative/dir/file", "relative/dir", true},
+		{"relative/directory", "relative/dir", false},
+		{"/etc", "", false},
+		{"", "", false},
+	}
+	for _, t := range tests {
+		c.Check(HasPathPrefix(t.path, t.prefix), Equals, t.match, Commentf("path %q prefix %q", t.path, t.prefix))
+	}
+}
+
+func (s *PathMatchSuite) TestPathPrefixes(c *C) {
+	pp := NewPathPrefixes("/dev", " /proc/ ", "", "/var/lib/../tmp")
+	c.Assert(pp, DeepEquals, PathPrefixes{"/dev", "/proc", "/var/tmp"})
+	tests := []struct {
+		path  string
+		match bool
+	}{
+		{"/dev", true},
+		{"/dev/null", true},
+		{"/devices", false},
+		{"/proc/1/status", true},
+		{"/var/tmp/x", true},
+		{"/var/lib", false},
+		{"/etc", false},
+	}
+	for _, t := range tests {
+		c.Check(pp.Match(t.path), Equals, t.match, Commentf("path %q", t.path))
+	}
+	c.Assert(NewPathPrefixes().Match("/"), Equals, false)
+}
+
+func (s *PathMatchSuite) TestOutermost(c *C) {
+	pp := NewPathPrefixes("/usr/bin", "/etc", "/usr", "/a/c", "/a-b", "/a", "/etc/").Outermost()
+	c.Assert(pp, DeepEquals, PathPrefixes{"/a", "/a-b", "/etc", "/usr"})
+	c.Assert(NewPathPrefixes("/srv", "/").Outermost(), DeepEquals, PathPrefixes{"/"})
+	c.Assert(NewPathPrefixes().Outermost(), HasLen, 0)
+}
+
+func (s *PathMatchSuite) TestLongest(c *C) {
+	pp := NewPathPrefixes("/", "/home", "/home/bob")
+	c.Assert(pp.Longest("/home/bob/.bashrc"), Equals, "/home/bob")
+	c.Assert(pp.Longest("/home/bobby"), Equals, "/home")
+	c.Assert(pp.Longest("/etc"), Equals, "/")
+	c.Assert(NewPathPrefixes("/usr").Longest("/etc"), Equals, "")
+}
+
+func (s *PathMatchSuite) TestWalkBefore(c *C) {
+	c.Assert(walkBefore("/a", "/a/b"), Equals, true)
+	c.Assert(walkBefore("/a/z", "/a-b"), Equals, true)
+	c.Assert(walkBefore("/a-b", "/a/z"), Equals, false)
+	c.Assert(walkBefore("/a/b", "/a/b"), Equals, false)
+	c.Assert(walkBefore("/b", "/a/b"), Equals, false)
+}
@@ -0,0 +1,299 @@
+package fcheck
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+//Attr is a set of file attributes that are recorded by Generator and checked by Comparator
+type Attr uint32
+
+//File attributes, the names are the ones used in policy files
+const (
+	AttrPerm    Attr = 1 << iota // p: file type and permission bits
+	AttrInode                    // i: inode number
+	AttrLinks                    // n: number of hard links
+	AttrUser                     // u: owner uid
+	AttrGroup                    // g: owner gid
+	AttrSize                     // s: size
+	AttrMtime                    // m: modification time
+	AttrCtime                    // c: inode change time
+	AttrGrowing                  // S: size may only grow
+	AttrSHA512                   // sha512: checksum of the contents
+	AttrSHA256                   // sha256: checksum of the contents, for records imported from other tools
+	AttrMD5                      // md5: checksum of the contents, for records imported from other tools
+	AttrEntropy                  // entropy: byte entropy of the contents, calculated while hashing (see MassChange)
+)
+
+//AttrContent are the content checksums, a record has at most one of them (the strongest) and that is the one checked
+const AttrContent = AttrSHA512 | AttrSHA256 | AttrMD5
+
+//DefaultAttrs are the attributes checked when no policy applies, also assumed for records of older DBs
+const DefaultAttrs = AttrPerm | AttrSize | AttrMtime | AttrSHA512
+
+var attrNames = []struct {
+	name string
+	attr Attr
+}{
+	{"p", AttrPerm},
+	{"i", AttrInode},
+	{"n", AttrLinks},
+	{"u", AttrUser},
+	{"g", AttrGroup},
+	{"s", AttrSize},
+	{"m", AttrMtime},
+	{"c", AttrCtime},
+	{"S", AttrGrowing},
+	{"sha512", AttrSHA512},
+	{"sha256", AttrSHA256},
+	{"md5", AttrMD5},
+	{"entropy", AttrEntropy},
+}
+
+//builtinGroups are the attribute groups every policy starts with
+var builtinGroups = map[string]Attr{
+	"R":       AttrPerm | AttrInode | AttrLinks | AttrUser | AttrGroup | AttrSize | AttrMtime | AttrCtime | AttrSHA512,
+	"L":       AttrPerm | AttrInode | AttrLinks | AttrUser | AttrGroup,
+	">":       AttrPerm | AttrUser | AttrGroup | AttrInode | AttrLinks | AttrGrowing,
+	"E":       0,
+	"DEFAULT": DefaultAttrs,
+}
+
+//String returns the attributes in policy file notation (e.g. p+u+g+sha512)
+func (a Attr) String() string {
+	var names []string
+	for _, v := range attrNames {
+		if a&v.attr != 0 {
+			names = append(names, v.name)
+		}
+	}
+	if len(names) == 0 {
+		return "E"
+	}
+	return strings.Join(names, "+")
+}
+
+//digest returns the content checksum a record with attributes a has
+func (a Attr) digest() Attr {
+	switch {
+	case a&AttrSHA512 != 0:
+		return AttrSHA512
+	case a&AttrSHA256 != 0:
+		return AttrSHA256
+	case a&AttrMD5 != 0:
+		return AttrMD5
+	}
+	return 0
+}
+
+//newHash returns the hash calculating the content checksum of a record with attributes a, SHA512 if it has none
+func (a Attr) newHash() hash.Hash {
+	switch a.digest() {
+	case AttrSHA256:
+		return sha256.New()
+	case AttrMD5:
+		return md5.New()
+	}
+	return sha512.New()
+}
+
+//anyDigest returns a with all content checksums if it has one, so that whichever a record has is checked
+func (a Attr) anyDigest() Attr {
+	if a&AttrContent != 0 {
+		return a | AttrContent
+	}
+	return a
+}
+
+//Policy maps path selectors to the attributes that are recorded and checked for them
+//
+//A policy file defines attribute groups and assigns them to paths, e.g.
+//
+//	# groups are built from attributes and other groups with + and -
+//	CONTENT = p+u+g+s+m+c+sha512
+//	PERMS = p+u+g
+//	/etc CONTENT
+//	/var/log >
+//	/home PERMS
+//	type=file /home/*/bin R-i
+//
+//Selectors use the same syntax as exclude rules (see Matcher) and the last matching one applies.
+//The attributes are p, i, n, u, g, s, m, c, S, sha512 and entropy (sha256 and md5 are only known from imported records,
+//any content checksum asked for checks whichever one the record has), the builtin groups are
+//R (p+i+n+u+g+s+m+c+sha512), L (p+i+n+u+g), > (growing log file: p+u+g+i+n+S), E (nothing)
+//and DEFAULT (p+s+m+sha512), which is what paths no selector matches get.
+//
+//Severity rules assign a Severity to findings of the Comparator, the last matching one applies:
+//
+//	# severity=<level> [kind=<kinds>] [changes=<attributes>] [only=<attributes>] [setuid] [shipped=<states>]
+//	#          [suspicious[=<reasons>]] <selector>
+//	severity=critical /sbin
+//	severity=critical setuid /
+//	severity=low kind=changed only=mtime /etc
+//
+//changes matches findings with any of the listed changes, only those with no other changes
+//and setuid those that gained the setuid or setgid bit. shipped matches findings annotated by Dpkg as
+//match, differ, unknown or unowned (see Finding.Shipped) and suspicious those Heuristics tagged, for any or
+//the listed reasons. Findings no rule matches are DefaultSeverity, ioc and mass-change findings only match rules
+//naming their kind.
+type Policy struct {
+	groups   map[string]Attr
+	rules    []policyRule
+	severity []*severityRule
+}
+
+type policyRule struct {
+	sel   *matchRule
+	attrs Attr
+}
+
+var (
+	groupDefRe = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(\S+)$`)
+	attrExprRe = regexp.MustCompile(`([+-]?)([^+-]+)`)
+)
+
+//NewPolicy returns new Policy with only the builtin groups, all paths get DefaultAttrs
+func NewPolicy() *Policy {
+	p := &Policy{groups: make(map[string]Attr)}
+	for k, v := range builtinGroups {
+		p.groups[k] = v
+	}
+	return p
+}
+
+//ParsePolicy returns new Policy with groups and rules read line by line from r
+func ParsePolicy(r io.Reader) (*Policy, error) {
+	p := NewPolicy()
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		if err := p.Add(scanner.Text()); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+	}
+	return p, scanner.Err()
+}
+
+//LoadPolicy returns new Policy read from file fname
+func LoadPolicy(fname string) (*Policy, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p, err := ParsePolicy(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fname, err)
+	}
+	return p, nil
+}
+
+//Add parses a single group definition or rule, blank lines and comments are ignored
+func (p *Policy) Add(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	if strings.HasPrefix(line, "severity=") {
+		r, err := parseSeverityRule(line)
+		if err != nil {
+			return err
+		}
+		p.severity = append(p.severity, r)
+		return nil
+	}
+	if m := groupDefRe.FindStringSubmatch(line); m != nil {
+		attrs, err := p.ParseAttrs(m[2])
+		if err != nil {
+			return err
+		}
+		p.groups[m[1]] = attrs
+		return nil
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return fmt.Errorf("expected a selector followed by attributes")
+	}
+	attrs, err := p.ParseAttrs(fields[len(fields)-1])
+	if err != nil {
+		return err
+	}
+	sel, err := parseRule(strings.Join(fields[:len(fields)-1], " "))
+	if err != nil {
+		return err
+	}
+	if sel.include {
+		return fmt.Errorf("! selectors are not supported, use the exclude rules instead")
+	}
+	p.rules = append(p.rules, policyRule{sel, attrs})
+	return nil
+}
+
+//ParseAttrs returns the attributes of expression expr made of attribute and group names joined by + and -
+func (p *Policy) ParseAttrs(expr string) (Attr, error) {
+	var attrs Attr
+	matches := attrExprRe.FindAllStringSubmatch(expr, -1)
+	matched := 0
+	for _, m := range matches {
+		matched += len(m[0])
+	}
+	if len(matches) == 0 || matched != len(expr) {
+		return 0, fmt.Errorf("bad attribute expression %q", expr)
+	}
+	for _, m := range matches {
+		a, ok := p.groups[m[2]]
+		if !ok {
+			for _, v := range attrNames {
+				if v.name == m[2] {
+					a, ok = v.attr, true
+				}
+			}
+		}
+		if !ok {
+			return 0, fmt.Errorf("unknown attribute or group %q", m[2])
+		}
+		if m[1] == "-" {
+			attrs &^= a
+		} else {
+			attrs |= a
+		}
+	}
+	return attrs, nil
+}
+
+//Records returns true if any of attrs applies to some paths
+func (p *Policy) Records(attrs Attr) bool {
+	if p == nil || len(p.rules) == 0 {
+		return DefaultAttrs&attrs != 0
+	}
+	for _, r := range p.rules {
+		if r.attrs&attrs != 0 {
+			return true
+		}
+	}
+	//paths no rule matches
+	return DefaultAttrs&attrs != 0
+}
+
+//Attrs returns the attributes that apply to path with mode
+func (p *Policy) Attrs(path string, mode os.FileMode) Attr {
+	if p == nil || len(p.rules) == 0 {
+		return DefaultAttrs
+	}
+	path = filepath.Clean(path)
+	parts := globParts(path)
+	for i := len(p.rules) - 1; i >= 0; i-- {
+		if p.rules[i].sel.match(path, parts, mode) {
+			return p.rules[i].attrs
+		}
+	}
+	return DefaultAttrs
+}
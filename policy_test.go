@@ -0,0 +1,125 @@
+package fcheck
+
+import (
+	"os"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type PolicySuite struct{}
+
+var _ = Suite(&PolicySuite{})
+
+const testPolicy = `
+# groups
+CONTENT = p+u+g+s+m+c+sha512
+PERMS = p+u+g
+NOTIME = R-m-c
+
+/etc CONTENT
+/var/log >
+/home PERMS
+type=file /home/*/bin NOTIME
+`
+
+func (s *PolicySuite) TestAttrs(c *C) {
+	p, err := ParsePolicy(strings.NewReader(testPolicy))
+	c.Assert(err, IsNil)
+	tests := []struct {
+		path  string
+		mode  os.FileMode
+		attrs string
+	}{
+		{"/etc/passwd", 0, "p+u+g+s+m+c+sha512"},
+		{"/etc", os.ModeDir, "p+u+g+s+m+c+sha512"},
+		{"/var/log/syslog", 0, "p+i+n+u+g+S"},
+		{"/home/bob/.bashrc", 0, "p+u+g"},
+		{"/home/bob/bin/tool", 0, "p+i+n+u+g+s+sha512"},
+		{"/home/bob/bin", os.ModeDir, "p+u+g"},
+		{"/usr/bin/ls", 0, "p+s+m+sha512"},
+	}
+	for _, t := range tests {
+		c.Check(p.Attrs(t.path, t.mode).String(), Equals, t.attrs, Commentf("path %q", t.path))
+	}
+	var nilp *Policy
+	c.Assert(nilp.Attrs("/etc", 0), Equals, DefaultAttrs)
+}
+
+func (s *PolicySuite) TestParseAttrs(c *C) {
+	p := NewPolicy()
+	a, err := p.ParseAttrs("p+u+g+sha512")
+	c.Assert(err, IsNil)
+	c.Assert(a, Equals, AttrPerm|AttrUser|AttrGroup|AttrSHA512)
+	a, err = p.ParseAttrs("R-sha512")
+	c.Assert(err, IsNil)
+	c.Assert(a&AttrSHA512, Equals, Attr(0))
+	c.Assert(a&AttrCtime, Equals, AttrCtime)
+	a, err = p.ParseAttrs("E")
+	c.Assert(err, IsNil)
+	c.Assert(a.String(), Equals, "E")
+	for _, expr := range []string{"", "p+x", "p++u", "+"} {
+		_, err = p.ParseAttrs(expr)
+		c.Check(err, NotNil, Commentf("expression %q", expr))
+	}
+}
+
+func (s *PolicySuite) TestBadPolicy(c *C) {
+	for _, line := range []string{"/etc", "/etc BOGUS", "!/etc R", "X = p+zz", "re:( R"} {
+		c.Check(NewPolicy().Add(line), NotNil, Commentf("line %q", line))
+	}
+}
+
+func (s *PolicySuite) TestSeverity(c *C) {
+	p, err := ParsePolicy(strings.NewReader(`
+severity=critical /sbin
+severity=low kind=changed only=mtime,ctime /etc
+severity=high kind=changed changes=digest /etc
+severity=critical setuid /
+`))
+	c.Assert(err, IsNil)
+	old := &FileCheckInfo{Path: "/usr/bin/tool", Mode: 0755}
+	suid := &FileCheckInfo{Path: "/usr/bin/tool", Mode: 0755 | os.ModeSetuid}
+	tests := []struct {
+		f        *Finding
+		severity Severity
+	}{
+		{&Finding{Kind: KindNew, Path: "/sbin/init", New: &FileCheckInfo{Path: "/sbin/init"}}, SeverityCritical},
+		{&Finding{Kind: KindChanged, Path: "/etc/hosts", Changes: []string{"mtime"}}, SeverityLow},
+		{&Finding{Kind: KindChanged, Path: "/etc/hosts", Changes: []string{"mtime", "size"}}, DefaultSeverity},
+		{&Finding{Kind: KindChanged, Path: "/etc/hosts", Changes: []string{"size", "digest"}}, SeverityHigh},
+		{&Finding{Kind: KindRemoved, Path: "/etc/hosts"}, DefaultSeverity},
+		{&Finding{Kind: KindChanged, Path: suid.Path, Changes: []string{"mode"}, Old: old, New: suid}, SeverityCritical},
+		{&Finding{Kind: KindChanged, Path: suid.Path, Changes: []string{"size"}, Old: suid, New: suid}, DefaultSeverity},
+		{&Finding{Kind: KindNew, Path: suid.Path, New: suid}, SeverityCritical},
+	}
+	for _, t := range tests {
+		c.Check(p.Severity(t.f), Equals, t.severity, Commentf("%s %s %v", t.f.Kind, t.f.Path, t.f.Changes))
+	}
+	var nilp *Policy
+	c.Assert(nilp.Severity(tests[0].f), Equals, DefaultSeverity)
+
+	//alerts are only rated by rules naming their kind
+	p, err = ParsePolicy(strings.NewReader("severity=low /var/cache\nseverity=high kind=ioc /var/cache/pkg\n"))
+	c.Assert(err, IsNil)
+	c.Assert(p.Severity(&Finding{Kind: KindIOC, Path: "/var/cache/x"}), Equals, SeverityCritical)
+	c.Assert(p.Severity(&Finding{Kind: KindMassChange, Path: "/var/cache"}), Equals, SeverityCritical)
+	c.Assert(p.Severity(&Finding{Kind: KindIOC, Path: "/var/cache/pkg/x"}), Equals, SeverityHigh)
+}
+
+func (s *PolicySuite) TestBadSeverity(c *C) {
+	for _, line := range []string{"severity=urgent /etc", "severity=high", "severity=high kind=changed", "severity=high !/etc",
+		"severity=high knd=changed /etc", "severity=high kind=chnaged /etc", "severity=high kind= /etc",
+		"severity=high kind=expected /etc", "severity=high changes=digest,perm /etc", "severity=high only=mtime, /etc",
+		"severity=high shipped=yes /", "severity=high suspicious=setuid,hiden /tmp"} {
+		c.Check(NewPolicy().Add(line), NotNil, Commentf("line %q", line))
+	}
+	//selectors may have = in them
+	for _, line := range []string{"severity=low type=socket /run", "severity=low kind=new re:^/srv/a=b", "severity=low /srv/a=b"} {
+		c.Check(NewPolicy().Add(line), IsNil, Commentf("line %q", line))
+	}
+	sev, err := ParseSeverity("high")
+	c.Assert(err, IsNil)
+	c.Assert(sev, Equals, SeverityHigh)
+	c.Assert(sev.String(), Equals, "high")
+}
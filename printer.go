@@ -1,10 +1,10 @@
 package fcheck
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
-	"strings"
 )
 
 //Printer represents a DB only walker that displays entries in previously generated db (flag show)
@@ -19,17 +19,25 @@ func NewPrinter(dbfname string) *Printer {
 }
 
 //StartWalking does the actual display of requested (flag -path) it respect excludes (flag -exclude_from)
-func (r *Printer) StartWalking(path string, exclude StringSet) error {
+//apart from filesystem type rules, the entries need not be on the filesystems mounted here
+func (r *Printer) StartWalking(ctx context.Context, roots []string, exclude *Matcher) error {
 	const layout = "2006-01-02 15:04:05 (MST)"
-	excludes := exclude.Items()
-	return r.Map(path, func(fc *FileCheckInfo) error {
-		for _, v := range excludes {
-			if strings.HasPrefix(fc.Path, v) {
+	walk := NewPathPrefixes(roots...).Outermost()
+	for _, root := range walk {
+		err := r.Map(root, func(fc *FileCheckInfo) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if walk.Longest(fc.Path) != root || exclude.dbExcluded(fc.Path, fc.Mode) {
 				//path is excluded
 				return nil
 			}
+			fmt.Fprintf(r.console, "%s %s %s %s\n", fc.Mode.String(), fc.ModTime.Format(layout), fc.HexDigest(), fc.Path)
+			return nil
+		})
+		if err != nil {
+			return err
 		}
-		fmt.Fprintf(r.console, "%s %s %s %s\n", fc.Mode.String(), fc.ModTime.Format(layout), fc.HexDigest(), fc.Path)
-		return nil
-	})
+	}
+	return nil
 }
@@ -0,0 +1,192 @@
+package fcheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+//Progress counts what Generator and Comparator have done so far, it is safe for concurrent use
+//a nil *Progress ignores all updates
+type Progress struct {
+	entries int64 // filesystem entries processed
+	bytes   int64 // bytes hashed
+	total   int64 // entries expected, 0 while unknown
+	started time.Time
+	path    atomic.Value // directory being walked
+}
+
+//ProgressInfo is a snapshot of Progress
+type ProgressInfo struct {
+	Time        time.Time     `json:"time"`
+	Entries     int64         `json:"entries"`
+	Total       int64         `json:"total,omitempty"` // 0 while unknown
+	Bytes       int64         `json:"bytes_hashed"`
+	Elapsed     time.Duration `json:"-"`
+	ElapsedSec  float64       `json:"elapsed_seconds"`
+	BytesPerSec float64       `json:"bytes_per_second"`
+	EntriesSec  float64       `json:"entries_per_second"`
+	ETA         time.Duration `json:"-"`
+	ETASec      float64       `json:"eta_seconds,omitempty"` // 0 while unknown
+	Path        string        `json:"path,omitempty"`
+}
+
+//NewProgress returns new Progress counting from now
+func NewProgress() *Progress {
+	return &Progress{started: time.Now()}
+}
+
+//SetTotal sets the number of entries expected, used to estimate the time left
+func (p *Progress) SetTotal(n int64) {
+	if p != nil {
+		atomic.StoreInt64(&p.total, n)
+	}
+}
+
+//Total returns the number of entries expected or 0 if unknown
+func (p *Progress) Total() int64 {
+	if p == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&p.total)
+}
+
+func (p *Progress) entryDone() {
+	if p != nil {
+		atomic.AddInt64(&p.entries, 1)
+	}
+}
+
+func (p *Progress) hashed(n int64) {
+	if p != nil {
+		atomic.AddInt64(&p.bytes, n)
+	}
+}
+
+func (p *Progress) enter(dir string) {
+	if p != nil {
+		p.path.Store(dir)
+	}
+}
+
+//Snapshot returns the current progress along with throughput and estimated time left
+func (p *Progress) Snapshot() ProgressInfo {
+	if p == nil {
+		return ProgressInfo{Time: time.Now()}
+	}
+	now := time.Now()
+	pi := ProgressInfo{
+		Time:    now,
+		Entries: atomic.LoadInt64(&p.entries),
+		Total:   atomic.LoadInt64(&p.total),
+		Bytes:   atomic.LoadInt64(&p.bytes),
+		Elapsed: now.Sub(p.started),
+	}
+	pi.Path, _ = p.path.Load().(string)
+	pi.ElapsedSec = pi.Elapsed.Seconds()
+	if pi.ElapsedSec > 0 {
+		pi.BytesPerSec = float64(pi.Bytes) / pi.ElapsedSec
+		pi.EntriesSec = float64(pi.Entries) / pi.ElapsedSec
+	}
+	if pi.Entries > 0 && pi.Total > pi.Entries {
+		pi.ETA = time.Duration(float64(pi.Elapsed) * float64(pi.Total-pi.Entries) / float64(pi.Entries)).Round(time.Second)
+		pi.ETASec = pi.ETA.Seconds()
+	}
+	return pi
+}
+
+func (pi ProgressInfo) String() string {
+	s := fmt.Sprintf("%d", pi.Entries)
+	if pi.Total > 0 {
+		s = fmt.Sprintf("%d/%d (%d%%)", pi.Entries, pi.Total, pi.Entries*100/pi.Total)
+	}
+	s = fmt.Sprintf("%s entries, %s hashed, %s/s, elapsed %s", s, formatBytes(pi.Bytes), formatBytes(int64(pi.BytesPerSec)),
+		pi.Elapsed.Round(time.Second))
+	if pi.ETA > 0 {
+		s += fmt.Sprintf(", ETA %s", pi.ETA)
+	}
+	if pi.Path != "" {
+		s += ", in " + pi.Path
+	}
+	return s
+}
+
+//formatBytes returns n in human readable binary units
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+//Write writes a single line with the current progress to w, format is FormatText or FormatJSON
+func (p *Progress) Write(w io.Writer, format string) error {
+	pi := p.Snapshot()
+	if format == FormatJSON {
+		return json.NewEncoder(w).Encode(&pi)
+	}
+	_, err := fmt.Fprintf(w, "progress: %s\n", pi)
+	return err
+}
+
+//Run writes the progress to w every interval until ctx is done
+func (p *Progress) Run(ctx context.Context, w io.Writer, format string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.Write(w, format)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+//countWalk counts the entries below roots that are not excluded, without reading any file
+//it is the pre-pass giving the total when there is no previous DB to take it from, with a walkScope of its own
+func countWalk(ctx context.Context, roots PathPrefixes, exclude *Matcher) int64 {
+	var n int64
+	scope := exclude.newScope(roots)
+	for _, root := range scope.roots {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if cerr := ctx.Err(); cerr != nil {
+				return cerr
+			}
+			if excluded, skip := scope.excluded(path, info); excluded {
+				return skip
+			}
+			n++
+			return nil
+		})
+	}
+	return n
+}
+
+//countDB returns the number of entries below roots in the DB dbfname
+func countDB(dbfname string, roots PathPrefixes) (int64, error) {
+	r := NewDBReader(dbfname)
+	if err := r.Start(); err != nil {
+		return 0, err
+	}
+	defer r.Stop()
+	if err := r.GenerateIndex(); err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, root := range roots {
+		n += r.Count(root)
+	}
+	return n, nil
+}
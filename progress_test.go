@@ -0,0 +1,48 @@
+package fcheck
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ProgressSuite struct{}
+
+var _ = Suite(&ProgressSuite{})
+
+func (s *ProgressSuite) TestSnapshot(c *C) {
+	p := NewProgress()
+	p.started = time.Now().Add(-10 * time.Second)
+	for i := 0; i < 25; i++ {
+		p.entryDone()
+	}
+	p.hashed(3 << 20)
+	p.enter("/usr/lib")
+	pi := p.Snapshot()
+	c.Assert(pi.Entries, Equals, int64(25))
+	c.Assert(pi.ETA, Equals, time.Duration(0))
+	p.SetTotal(100)
+	pi = p.Snapshot()
+	//a quarter done in 10s
+	c.Assert(pi.ETA, Equals, 30*time.Second)
+	c.Assert(strings.HasPrefix(pi.String(), "25/100 (25%) entries, 3.0 MiB hashed, "), Equals, true)
+	c.Assert(strings.HasSuffix(pi.String(), ", ETA 30s, in /usr/lib"), Equals, true)
+	var buf bytes.Buffer
+	c.Assert(p.Write(&buf, FormatJSON), IsNil)
+	var doc map[string]interface{}
+	c.Assert(json.Unmarshal(buf.Bytes(), &doc), IsNil)
+	c.Assert(doc["total"], Equals, float64(100))
+	c.Assert(doc["eta_seconds"], Equals, float64(30))
+	var nilp *Progress
+	nilp.entryDone()
+	c.Assert(nilp.Snapshot().Entries, Equals, int64(0))
+}
+
+func (s *ProgressSuite) TestFormatBytes(c *C) {
+	c.Assert(formatBytes(512), Equals, "512 B")
+	c.Assert(formatBytes(1536), Equals, "1.5 KiB")
+	c.Assert(formatBytes(5<<30), Equals, "5.0 GiB")
+}
@@ -0,0 +1,327 @@
+package fcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+//Kinds of findings reported by Comparator
+const (
+	KindNew        = "new"
+	KindChanged    = "changed"
+	KindRemoved    = "removed"
+	KindMoved      = "moved"       // moved or renamed, the original is gone
+	KindCopied     = "copied"      // new file identical to one that still exists
+	KindUnreadable = "unreadable"  // could not be walked or hashed, entries below unreadable directories are not checked
+	KindIOC        = "ioc"         // content is on a known-bad hash list (see HashList), whether it changed or not
+	KindMassChange = "mass-change" // most files of a directory or many files changed at once (see MassChange)
+	//not a kind of its own, new, copied and changed findings that are Expected are counted and listed apart as this
+	KindExpected = "expected"
+)
+
+//Report formats understood by NewReporter
+const (
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+//Finding represents a single difference between the DB and the filesystem
+type Finding struct {
+	Kind      string         `json:"kind"`
+	Severity  Severity       `json:"severity"`
+	Path      string         `json:"path"`
+	From      string         `json:"from,omitempty"`    // original path of moved and copied files
+	Error     string         `json:"error,omitempty"`   // why an unreadable file could not be checked
+	Changes   []string       `json:"changes,omitempty"` // attributes that differ (changed files only)
+	Old       *FileCheckInfo `json:"old,omitempty"`     // record from the DB
+	New       *FileCheckInfo `json:"new,omitempty"`     // record from the filesystem
+	Package   string         `json:"package,omitempty"` // package owning the file (see Dpkg)
+	Conffile  bool           `json:"conffile,omitempty"`
+	Shipped   string         `json:"shipped,omitempty"`   // how the content compares with the one the package shipped
+	Indicator string         `json:"indicator,omitempty"` // label of the known-bad checksum matched (ioc findings)
+	Expected  string         `json:"expected,omitempty"`  // why the file is expected, set for findings matching the Allowlist
+	//why the file looks like an intrusion, see Heuristics
+	Suspicious []string `json:"suspicious,omitempty"`
+	Summary    string   `json:"summary,omitempty"` // what a mass-change alert counted
+	//the kind f was listed as when it was reported before, set when a new file turns out to be moved or copied
+	revises string
+	//the content is on the known-bad hash list, such a file is never expected
+	knownBad bool
+}
+
+//listedAs returns the kind f is listed as in reports, expected findings are listed apart
+func (f *Finding) listedAs() string {
+	if f.Expected != "" {
+		return KindExpected
+	}
+	return f.Kind
+}
+
+//How the content of a file compares with the one its package shipped, see Dpkg
+const (
+	ShippedMatch   = "match"   // it is the content the package shipped
+	ShippedDiffer  = "differ"  // it is not
+	ShippedUnknown = "unknown" // the package ships no checksum for it or the file could not be read
+	ShippedUnowned = "unowned" // no package owns the file
+)
+
+//note returns what annotators added to f for the text report, "" if nothing
+func (f *Finding) note() string {
+	var notes []string
+	switch {
+	case f.Indicator != "":
+		notes = append(notes, f.Indicator)
+	case f.Summary != "":
+		notes = append(notes, f.Summary)
+	case f.Expected != "":
+		notes = append(notes, f.Kind+", "+f.Expected)
+	case f.Package != "":
+		note := f.Package
+		if f.Conffile {
+			note += " conffile"
+		}
+		switch f.Shipped {
+		case ShippedMatch:
+			note += ", as shipped"
+		case ShippedDiffer:
+			note += ", not as shipped"
+		}
+		notes = append(notes, note)
+	case f.Shipped == ShippedUnowned:
+		notes = append(notes, "no package")
+	}
+	if len(f.Suspicious) > 0 {
+		notes = append(notes, "suspicious: "+strings.Join(f.Suspicious, ", "))
+	}
+	if len(notes) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(notes, "; ") + "]"
+}
+
+//RunInfo holds metadata about a single comparison run
+type RunInfo struct {
+	Roots       []string  `json:"roots"`
+	DB          string    `json:"db"`
+	Against     string    `json:"against,omitempty"` // the DB compared with instead of the filesystem, when diffing DBs
+	Host        string    `json:"host,omitempty"`
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished"`
+	Checked     int64     `json:"checked"` // number of filesystem entries examined
+	MinSeverity Severity  `json:"min_severity"`
+	Suppressed  int       `json:"suppressed"`        // findings below MinSeverity that were left out
+	Resumed     bool      `json:"resumed,omitempty"` // continued from a checkpoint, Started is when the first attempt started
+	//content checks, files whose digest was compared and those left out by the HashBudget (when Sampled)
+	Sampled        bool   `json:"sampled,omitempty"`
+	ContentChecked int64  `json:"content_checked"`
+	ContentSkipped int64  `json:"content_skipped"`
+	Cursor         string `json:"cursor,omitempty"` // where the next rotating run continues hashing
+	//set when the walk was interrupted, only what was walked before InterruptedAt is reported
+	Incomplete    bool     `json:"incomplete"`
+	InterruptedAt string   `json:"interrupted_at,omitempty"`
+	NotCovered    []string `json:"not_covered,omitempty"` // directories and roots that were not (completely) walked
+}
+
+//ContentCoverage describes the fraction of files whose content was checked
+func (run *RunInfo) ContentCoverage() string {
+	total := run.ContentChecked + run.ContentSkipped
+	if total == 0 {
+		return "0 of 0 files"
+	}
+	return fmt.Sprintf("%d of %d files (%.1f%%)", run.ContentChecked, total, float64(run.ContentChecked)*100/float64(total))
+}
+
+//Reporter renders the findings of Comparator
+//Finding is called as soon as a difference is detected, End once the comparison is over
+type Reporter interface {
+	Begin(run *RunInfo) error
+	Finding(f *Finding) error
+	End(run *RunInfo) error
+}
+
+//suppressedReporter is a Reporter that also takes the findings left out of the report by Comparator.SetMinSeverity
+type suppressedReporter interface {
+	Suppressed(f *Finding) error
+}
+
+//NewReporter returns a Reporter writing in format (text, json or ndjson) to w
+func NewReporter(format string, w io.Writer) (Reporter, error) {
+	switch format {
+	case FormatText, "":
+		return &TextReporter{out: w}, nil
+	case FormatJSON:
+		return &JSONReporter{out: w}, nil
+	case FormatNDJSON:
+		return &NDJSONReporter{enc: json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown report format %q", format)
+}
+
+//findingList collects findings grouped by their kind
+type findingList struct {
+	all    []*Finding
+	byKind map[string][]*Finding
+}
+
+//add lists f, a revised finding is moved from what it was listed as before
+func (r *findingList) add(f *Finding) {
+	if r.byKind == nil {
+		r.byKind = make(map[string][]*Finding)
+	}
+	if listed := r.byKind[f.revises]; f.revises != "" {
+		for i, v := range listed {
+			if v == f {
+				r.byKind[f.revises] = append(listed[:i], listed[i+1:]...)
+				break
+			}
+		}
+	} else {
+		r.all = append(r.all, f)
+	}
+	kind := f.listedAs()
+	r.byKind[kind] = append(r.byKind[kind], f)
+}
+
+func (r *findingList) counts() map[string]int {
+	return map[string]int{
+		KindNew:        len(r.byKind[KindNew]),
+		KindChanged:    len(r.byKind[KindChanged]),
+		KindRemoved:    len(r.byKind[KindRemoved]),
+		KindMoved:      len(r.byKind[KindMoved]),
+		KindCopied:     len(r.byKind[KindCopied]),
+		KindUnreadable: len(r.byKind[KindUnreadable]),
+		KindIOC:        len(r.byKind[KindIOC]),
+		KindMassChange: len(r.byKind[KindMassChange]),
+		KindExpected:   len(r.byKind[KindExpected]),
+	}
+}
+
+//TextReporter prints the human readable report once the comparison is over
+type TextReporter struct {
+	out io.Writer
+	findingList
+}
+
+//Begin implements Reporter
+func (r *TextReporter) Begin(run *RunInfo) error {
+	return nil
+}
+
+//Finding implements Reporter
+func (r *TextReporter) Finding(f *Finding) error {
+	r.add(f)
+	return nil
+}
+
+//End implements Reporter
+func (r *TextReporter) End(run *RunInfo) error {
+	sections := []struct {
+		title string
+		kind  string
+	}{
+		{"Changed files", KindChanged},
+		{"New files", KindNew},
+		{"Deleted files", KindRemoved},
+		{"Moved files", KindMoved},
+		{"Copied files", KindCopied},
+		{"Unreadable files", KindUnreadable},
+		{"Known bad files", KindIOC},
+		{"Mass changes", KindMassChange},
+		{"Expected files", KindExpected},
+	}
+	for _, s := range sections {
+		fmt.Fprintf(r.out, "\n\n%s %d\n\n", s.title, len(r.byKind[s.kind]))
+		for _, v := range sortBySeverity(r.byKind[s.kind]) {
+			switch {
+			case v.From != "":
+				fmt.Fprintf(r.out, "%-8s %s -> %s%s\n", v.Severity, v.From, v.Path, v.note())
+			case v.Error != "":
+				fmt.Fprintf(r.out, "%-8s %s (%s)%s\n", v.Severity, v.Path, v.Error, v.note())
+			default:
+				fmt.Fprintf(r.out, "%-8s %s%s\n", v.Severity, v.Path, v.note())
+			}
+		}
+	}
+	if run.Suppressed > 0 {
+		fmt.Fprintf(r.out, "\n\n%d findings below %s not shown\n", run.Suppressed, run.MinSeverity)
+	}
+	if run.Sampled {
+		fmt.Fprintf(r.out, "\n\nContent checked %s\n", run.ContentCoverage())
+	}
+	if run.Incomplete {
+		fmt.Fprintf(r.out, "\n\nINCOMPLETE: interrupted at %s, not (completely) checked %d\n\n", run.InterruptedAt, len(run.NotCovered))
+		for _, v := range run.NotCovered {
+			fmt.Fprintln(r.out, v)
+		}
+	}
+	return nil
+}
+
+//JSONReporter writes a single JSON document once the comparison is over, findings are sorted by severity
+type JSONReporter struct {
+	out io.Writer
+	findingList
+}
+
+//Begin implements Reporter
+func (r *JSONReporter) Begin(run *RunInfo) error {
+	return nil
+}
+
+//Finding implements Reporter
+func (r *JSONReporter) Finding(f *Finding) error {
+	r.add(f)
+	return nil
+}
+
+//End implements Reporter
+func (r *JSONReporter) End(run *RunInfo) error {
+	findings := sortBySeverity(r.all)
+	doc := struct {
+		Run      *RunInfo       `json:"run"`
+		Counts   map[string]int `json:"counts"`
+		Findings []*Finding     `json:"findings"`
+	}{run, r.counts(), findings}
+	enc := json.NewEncoder(r.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+//NDJSONReporter streams one JSON event per line as findings are detected
+//new files found to be moved or copied at the end are sent again as revised events
+type NDJSONReporter struct {
+	enc *json.Encoder
+	findingList
+}
+
+type ndjsonEvent struct {
+	Event  string         `json:"event"`
+	Time   time.Time      `json:"time"`
+	Run    *RunInfo       `json:"run,omitempty"`
+	Counts map[string]int `json:"counts,omitempty"`
+	*Finding
+}
+
+//Begin implements Reporter
+func (r *NDJSONReporter) Begin(run *RunInfo) error {
+	return r.enc.Encode(&ndjsonEvent{Event: "start", Time: time.Now(), Run: run})
+}
+
+//Finding implements Reporter
+func (r *NDJSONReporter) Finding(f *Finding) error {
+	r.add(f)
+	if f.revises != "" {
+		//a new file reported before that turned out to be moved or copied
+		return r.enc.Encode(&ndjsonEvent{Event: "revised", Time: time.Now(), Finding: f})
+	}
+	return r.enc.Encode(&ndjsonEvent{Event: "finding", Time: time.Now(), Finding: f})
+}
+
+//End implements Reporter
+func (r *NDJSONReporter) End(run *RunInfo) error {
+	return r.enc.Encode(&ndjsonEvent{Event: "end", Time: time.Now(), Run: run, Counts: r.counts()})
+}
@@ -0,0 +1,79 @@
+package fcheck
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ReportSuite struct{}
+
+var _ = Suite(&ReportSuite{})
+
+func (s *ReportSuite) feed(c *C, format string) *bytes.Buffer {
+	var buf bytes.Buffer
+	rep, err := NewReporter(format, &buf)
+	c.Assert(err, IsNil)
+	run := &RunInfo{Roots: []string{"/made"}, DB: "test.db", Started: time.Now()}
+	c.Assert(rep.Begin(run), IsNil)
+	old := &FileCheckInfo{Path: "/made/up", Size: 3, ModTime: time.Now(), Digest: []byte("abc")}
+	cur := &FileCheckInfo{Path: "/made/up", Size: 4, ModTime: old.ModTime, Digest: []byte("abcd")}
+	c.Assert(rep.Finding(&Finding{Kind: KindChanged, Severity: SeverityHigh, Path: cur.Path, Changes: cur.Diff(old, DefaultAttrs), Old: old, New: cur}), IsNil)
+	c.Assert(rep.Finding(&Finding{Kind: KindNew, Severity: SeverityInfo, Path: "/made/new", New: &FileCheckInfo{Path: "/made/new", Mode: os.ModeDir}}), IsNil)
+	c.Assert(rep.Finding(&Finding{Kind: KindNew, Severity: SeverityCritical, Path: "/made/suid", New: &FileCheckInfo{Path: "/made/suid", Mode: os.ModeSetuid}}), IsNil)
+	run.Checked = 2
+	c.Assert(rep.End(run), IsNil)
+	return &buf
+}
+
+func (s *ReportSuite) TestUnknownFormat(c *C) {
+	_, err := NewReporter("xml", &bytes.Buffer{})
+	c.Assert(err, NotNil)
+}
+
+func (s *ReportSuite) TestText(c *C) {
+	out := s.feed(c, FormatText).String()
+	c.Assert(strings.Contains(out, "Changed files 1\n\nhigh     /made/up\n"), Equals, true)
+	c.Assert(strings.Contains(out, "New files 2\n\ncritical /made/suid\ninfo     /made/new\n"), Equals, true)
+	c.Assert(strings.Contains(out, "Deleted files 0\n"), Equals, true)
+}
+
+func (s *ReportSuite) TestJSON(c *C) {
+	var doc struct {
+		Run      RunInfo
+		Counts   map[string]int
+		Findings []struct {
+			Kind     string
+			Severity Severity
+			Path     string
+			Changes  []string
+		}
+	}
+	c.Assert(json.Unmarshal(s.feed(c, FormatJSON).Bytes(), &doc), IsNil)
+	c.Assert(doc.Run.Checked, Equals, int64(2))
+	c.Assert(doc.Counts[KindChanged], Equals, 1)
+	c.Assert(doc.Counts[KindRemoved], Equals, 0)
+	c.Assert(doc.Findings, HasLen, 3)
+	//most alarming first
+	c.Assert(doc.Findings[0].Severity, Equals, SeverityCritical)
+	c.Assert(doc.Findings[1].Changes, DeepEquals, []string{"size", "digest"})
+}
+
+func (s *ReportSuite) TestNDJSON(c *C) {
+	var events []string
+	scanner := bufio.NewScanner(s.feed(c, FormatNDJSON))
+	for scanner.Scan() {
+		var ev struct {
+			Event string
+			Path  string
+		}
+		c.Assert(json.Unmarshal(scanner.Bytes(), &ev), IsNil)
+		events = append(events, ev.Event+":"+ev.Path)
+	}
+	c.Assert(events, DeepEquals, []string{"start:", "finding:/made/up", "finding:/made/new", "finding:/made/suid", "end:"})
+}
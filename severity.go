@@ -0,0 +1,232 @@
+package fcheck
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//Severity tells how alarming a Finding is
+type Severity int
+
+//Severity levels from the least to the most alarming
+const (
+	SeverityInfo Severity = iota
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+//DefaultSeverity is assigned to findings no severity rule matches, but for ioc and mass-change findings which are SeverityCritical
+//unless a rule naming their kind matches and suspicious ones (see Heuristics) which are SeverityHigh
+const DefaultSeverity = SeverityMedium
+
+var severityNames = []string{"info", "low", "medium", "high", "critical"}
+
+//ParseSeverity returns the Severity called name
+func ParseSeverity(name string) (Severity, error) {
+	for i, v := range severityNames {
+		if v == name {
+			return Severity(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity %q (expected one of %s)", name, strings.Join(severityNames, ", "))
+}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+//MarshalText implements encoding.TextMarshaler
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+//UnmarshalText implements encoding.TextUnmarshaler
+func (s *Severity) UnmarshalText(text []byte) error {
+	v, err := ParseSeverity(string(text))
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+//the values severity rule conditions take
+var (
+	severityKinds = []string{KindNew, KindChanged, KindRemoved, KindMoved, KindCopied, KindUnreadable, KindIOC, KindMassChange}
+	//as FileCheckInfo.Diff and Comparator name them
+	changeNames     = []string{"mode", "inode", "links", "user", "group", "size", "rotated", "shrunk", "rewritten", "mtime", "ctime", "digest", "entropy"}
+	shippedStates   = []string{ShippedMatch, ShippedDiffer, ShippedUnknown, ShippedUnowned}
+	suspiciousNames = []string{SuspiciousSetuid, SuspiciousWorldWritable, SuspiciousTimestomp, SuspiciousTempExec, SuspiciousHidden, SuspiciousControlChars}
+)
+
+//severityRule assigns severity to findings matching all of its conditions
+type severityRule struct {
+	severity Severity
+	sel      *matchRule
+	kinds    []string // finding kinds, any of them
+	changes  []string // changed attributes, any of them
+	only     []string // changed attributes, all of the changes have to be among them
+	setuid   bool     // setuid or setgid bit was gained
+	shipped  []string // how the content compares with the one its package shipped, any of them
+	//suspicious reasons, any of them, or any reason if suspicious is set without
+	suspicious []string
+	anyReason  bool
+}
+
+//parseSeverityRule parses
+//severity=<level> [kind=<kinds>] [changes=<attributes>] [only=<attributes>] [setuid] [shipped=<states>]
+//[suspicious[=<reasons>]] <selector>
+func parseSeverityRule(line string) (*severityRule, error) {
+	fields := strings.Fields(line)
+	sev, err := ParseSeverity(strings.TrimPrefix(fields[0], "severity="))
+	if err != nil {
+		return nil, err
+	}
+	r := &severityRule{severity: sev}
+	fields = fields[1:]
+	for len(fields) > 0 {
+		f := fields[0]
+		key := ""
+		if i := strings.IndexByte(f, '='); i > 0 {
+			key = f[:i]
+		}
+		switch {
+		case key == "kind":
+			r.kinds, err = parseValues(f, severityKinds)
+		case key == "changes":
+			r.changes, err = parseValues(f, changeNames)
+		case key == "only":
+			r.only, err = parseValues(f, changeNames)
+		case f == "setuid":
+			r.setuid = true
+		case key == "shipped":
+			r.shipped, err = parseValues(f, shippedStates)
+		case f == "suspicious":
+			r.anyReason = true
+		case key == "suspicious":
+			r.suspicious, err = parseValues(f, suspiciousNames)
+		case key != "" && key != "type" && key != "fstype" && !strings.HasPrefix(f, "re:") && !strings.ContainsAny(key, "/*?["):
+			//not a selector either
+			return nil, fmt.Errorf("unknown condition %q", key)
+		default:
+			//the rest is the selector
+			r.sel, err = parseRule(strings.Join(fields, " "))
+			if err != nil {
+				return nil, err
+			}
+			if r.sel.include {
+				return nil, fmt.Errorf("! selectors are not supported")
+			}
+			return r, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields = fields[1:]
+	}
+	return nil, fmt.Errorf("missing selector")
+}
+
+//parseValues returns the comma separated values of the condition key=values, each of which has to be one of known
+func parseValues(condition string, known []string) ([]string, error) {
+	i := strings.IndexByte(condition, '=')
+	values := strings.Split(condition[i+1:], ",")
+	for _, v := range values {
+		if !hasChange(known, v) {
+			return nil, fmt.Errorf("unknown %s %q (expected %s)", condition[:i], v, strings.Join(known, ", "))
+		}
+	}
+	return values, nil
+}
+
+func (r *severityRule) match(f *Finding) bool {
+	if len(r.kinds) > 0 && !hasChange(r.kinds, f.Kind) {
+		return false
+	}
+	if len(r.changes) > 0 && !hasChange(f.Changes, r.changes...) {
+		return false
+	}
+	if len(r.only) > 0 {
+		if len(f.Changes) == 0 {
+			return false
+		}
+		for _, c := range f.Changes {
+			if !hasChange(r.only, c) {
+				return false
+			}
+		}
+	}
+	if r.setuid && !gainedSetuid(f) {
+		return false
+	}
+	if len(r.shipped) > 0 && !hasChange(r.shipped, f.Shipped) {
+		return false
+	}
+	if r.anyReason && len(f.Suspicious) == 0 || len(r.suspicious) > 0 && !hasChange(f.Suspicious, r.suspicious...) {
+		return false
+	}
+	var mode os.FileMode
+	switch {
+	case f.New != nil:
+		mode = f.New.Mode
+	case f.Old != nil:
+		mode = f.Old.Mode
+	}
+	path := filepath.Clean(f.Path)
+	return r.sel.match(path, globParts(path), mode)
+}
+
+//gainedSetuid returns true if the file of f has setuid or setgid set now but did not before
+func gainedSetuid(f *Finding) bool {
+	const bits = os.ModeSetuid | os.ModeSetgid
+	if f.New == nil || f.New.Mode&bits == 0 {
+		return false
+	}
+	return f.Old == nil || f.New.Mode&bits&^f.Old.Mode != 0
+}
+
+//Severity returns the severity of f according to the last matching severity rule or DefaultSeverity
+//ioc and mass-change findings are only rated by rules naming their kind, they are SeverityCritical otherwise
+func (p *Policy) Severity(f *Finding) Severity {
+	alert := f.Kind == KindIOC || f.Kind == KindMassChange
+	if p != nil {
+		for i := len(p.severity) - 1; i >= 0; i-- {
+			r := p.severity[i]
+			if alert && !hasChange(r.kinds, f.Kind) {
+				//path rules do not quiet alerts
+				continue
+			}
+			if r.match(f) {
+				return r.severity
+			}
+		}
+	}
+	if alert {
+		return SeverityCritical
+	}
+	if len(f.Suspicious) > 0 {
+		return SeverityHigh
+	}
+	return DefaultSeverity
+}
+
+//sortBySeverity sorts findings from the most to the least alarming, then by path
+func sortBySeverity(findings []*Finding) []*Finding {
+	sorted := make([]*Finding, len(findings))
+	copy(sorted, findings)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Severity != sorted[j].Severity {
+			return sorted[i].Severity > sorted[j].Severity
+		}
+		return sorted[i].Path < sorted[j].Path
+	})
+	return sorted
+}
@@ -0,0 +1,20 @@
+package fcheck
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+//fillStat copies ownership, inode, link count and ctime from info into fc
+func fillStat(fc *FileCheckInfo, info os.FileInfo) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	fc.Uid = st.Uid
+	fc.Gid = st.Gid
+	fc.Inode = st.Ino
+	fc.Nlink = uint64(st.Nlink)
+	fc.CTime = time.Unix(st.Ctim.Unix())
+}
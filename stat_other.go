@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package fcheck
+
+import "os"
+
+//fillStat is a no-op where the layout of the stat structure is unknown, only Size, Mode and ModTime are recorded
+func fillStat(fc *FileCheckInfo, info os.FileInfo) {
+}
@@ -1,5 +1,13 @@
 package fcheck
 
+import (
+	"context"
+	"errors"
+)
+
+//errNoRoots is returned by walkers given no paths to walk
+var errNoRoots = errors.New("no paths to walk")
+
 //StringSet represents a simple set of strings
 type StringSet map[string]int8
 
@@ -28,12 +36,30 @@ func (r *StringSet) Items() []string {
 	return v
 }
 
-//Walker represents an object that can be initialized/destroyed before/after a filepath is walked
+//Walker represents an object that can be initialized/destroyed before/after filepaths are walked
+//roots nested in other roots are walked only once, walking stops with ctx.Err() when ctx is cancelled
 type Walker interface {
-	StartWalking(path string, exclude StringSet) error
+	StartWalking(ctx context.Context, roots []string, exclude *Matcher) error
 	StartStopper
 }
 
+//ErrorCounter is implemented by walkers that keep going when parts of the filesystem can not be walked
+type ErrorCounter interface {
+	WalkErrors() int
+}
+
+//Annotator adds what it knows about a file to the findings of Comparator, before their severity is assigned
+type Annotator interface {
+	Annotate(f *Finding)
+}
+
+//ContentAnnotator is an Annotator that reads the file of a finding, Comparator calls AnnotateContent on new and
+//changed files in its compare workers (concurrently) and Annotate later, along with the other annotators
+type ContentAnnotator interface {
+	Annotator
+	AnnotateContent(f *Finding)
+}
+
 //StartStopper represents an object that can be initialized/destroyed by calling Start and Stop
 type StartStopper interface {
 	Start() error
@@ -43,7 +69,16 @@ type StartStopper interface {
 //FileInfoWriter is an interface for writing FileCheckInfo records to DB
 type FileInfoWriter interface {
 	StartStopper
+	PutHeader(h *DBHeader) error
 	Put(fc *FileCheckInfo) error
+	Abort() error
+}
+
+//CheckpointWriter is implemented by FileInfoWriters that can continue an interrupted DB, it is needed to checkpoint a Generator
+type CheckpointWriter interface {
+	Sync() (int64, error)
+	StartAt(size int64) error
+	Suspend() error
 }
 
 //FileInfoReader is an interface for reading FileCheckInfo records from DB
@@ -51,7 +86,10 @@ type FileInfoReader interface {
 	StartStopper
 	Get(path string) (*FileCheckInfo, error)
 	Map(path string, callback DBMapFunc) error
+	Paths(path string, f func(path string) error) error
 	GenerateIndex() error
+	Header() *DBHeader
+	Count(path string) int64
 }
 
 //DBMapFunc is the callback function definition used by Map
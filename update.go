@@ -0,0 +1,316 @@
+package fcheck
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+//errIncompleteUpdate is returned by Updater.Commit after an interrupted check
+var errIncompleteUpdate = errors.New("the check was incomplete, not updating the db")
+
+//Reviewer decides which findings Updater accepts into the DB
+type Reviewer interface {
+	Review(f *Finding) (bool, error)
+}
+
+//Updater is a Reporter that collects the findings of a Comparator and then writes them into its DB, so that
+//accepted changes become the new baseline without regenerating it; the rest of the DB is copied as it is
+//and the accepted files are recorded as they were found, findings are also passed on to the Reporter rendering the report
+//findings left out of the report by a minimum severity are accepted like the others, only they are not passed on
+type Updater struct {
+	Reporter
+	dbfile   string
+	paths    PathPrefixes // only findings below these are accepted, all if empty
+	kinds    StringSet    // only findings of these kinds are accepted, all if empty
+	reviewer Reviewer
+	policy   *Policy
+	findings []*Finding
+	records  map[*Finding]*FileCheckInfo // what the files of the findings are recorded as, taken when they are found
+	run      *RunInfo
+	history  *History
+	label    string
+}
+
+//NewUpdater returns new Updater for the DB in dbfname passing findings on to rep (which may be nil)
+func NewUpdater(dbfname string, rep Reporter) *Updater {
+	return &Updater{Reporter: rep, dbfile: dbfname, records: make(map[*Finding]*FileCheckInfo)}
+}
+
+//SetPaths limits the findings accepted to those at or below paths
+func (u *Updater) SetPaths(paths ...string) {
+	u.paths = NewPathPrefixes(paths...)
+}
+
+//SetKinds limits the findings accepted to those of kinds (new, changed, removed, moved and copied)
+func (u *Updater) SetKinds(kinds ...string) error {
+	u.kinds = make(StringSet)
+	for _, k := range kinds {
+		switch k = strings.TrimSpace(k); k {
+		case KindNew, KindChanged, KindRemoved, KindMoved, KindCopied:
+			u.kinds.Add(k)
+		default:
+			return fmt.Errorf("can not accept findings of kind %q", k)
+		}
+	}
+	return nil
+}
+
+//SetReviewer sets the Reviewer asked about each finding, by default all findings are accepted
+func (u *Updater) SetReviewer(r Reviewer) {
+	u.reviewer = r
+}
+
+//SetHistory makes the Updater keep the DB it replaces as a previous generation in h
+func (u *Updater) SetHistory(h *History) {
+	u.history = h
+}
+
+//SetLabel sets the label recorded in the header of the updated DB
+func (u *Updater) SetLabel(label string) {
+	u.label = label
+}
+
+//SetPolicy sets the Policy deciding which attributes are recorded for accepted files, it should be the one checked with
+func (u *Updater) SetPolicy(p *Policy) {
+	u.policy = p
+}
+
+//Begin implements Reporter
+func (u *Updater) Begin(run *RunInfo) error {
+	u.run = run
+	if u.Reporter == nil {
+		return nil
+	}
+	return u.Reporter.Begin(run)
+}
+
+//Finding implements Reporter
+func (u *Updater) Finding(f *Finding) error {
+	u.collect(f)
+	if u.Reporter == nil {
+		return nil
+	}
+	return u.Reporter.Finding(f)
+}
+
+//Suppressed takes a finding left out of the report, it is accepted or rejected like the reported ones
+func (u *Updater) Suppressed(f *Finding) error {
+	u.collect(f)
+	return nil
+}
+
+//collect keeps f for Commit, along with the record of its file if it may be accepted
+//a new file revised as moved or copied is collected again and gets its record then if only those kinds are accepted
+func (u *Updater) collect(f *Finding) {
+	if f.revises == "" {
+		//revised findings are collected already
+		u.findings = append(u.findings, f)
+	}
+	if f.New != nil && u.selects(f) {
+		//the content reported and reviewed is the one recorded, not what the file has by the time of Commit
+		u.records[f] = u.record(f.New)
+	}
+}
+
+//End implements Reporter
+func (u *Updater) End(run *RunInfo) error {
+	u.run = run
+	if u.Reporter == nil {
+		return nil
+	}
+	return u.Reporter.End(run)
+}
+
+//acceptable returns false for the findings that are never accepted: unreadable and known-bad files and alerts
+func acceptable(f *Finding) bool {
+	return f.Kind != KindUnreadable && f.Kind != KindIOC && f.Kind != KindMassChange
+}
+
+//selects returns true if f may be accepted
+func (u *Updater) selects(f *Finding) bool {
+	if !acceptable(f) {
+		return false
+	}
+	if len(u.kinds) > 0 && !u.kinds.Has(f.Kind) {
+		return false
+	}
+	return len(u.paths) == 0 || u.paths.Match(f.Path)
+}
+
+//Commit reviews the findings and writes the accepted ones to the DB, it has to be called once the Comparator stopped
+//it returns the number of findings accepted and rejected, the DB is left as it was if none was accepted
+func (u *Updater) Commit() (accepted, rejected int, err error) {
+	if u.run == nil || u.run.Incomplete {
+		return 0, 0, errIncompleteUpdate
+	}
+	//path -> new record, nil to drop the entry
+	records := make(map[string]*FileCheckInfo)
+	findings := make([]*Finding, len(u.findings))
+	copy(findings, u.findings)
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Path < findings[j].Path })
+	for _, f := range findings {
+		ok := u.selects(f)
+		if ok && u.reviewer != nil {
+			if ok, err = u.reviewer.Review(f); err != nil {
+				return accepted, rejected, err
+			}
+		}
+		if !ok {
+			rejected++
+			continue
+		}
+		accepted++
+		switch f.Kind {
+		case KindRemoved:
+			records[f.Path] = nil
+		case KindMoved:
+			records[f.From] = nil
+			records[f.Path] = u.records[f]
+		default:
+			records[f.Path] = u.records[f]
+		}
+	}
+	if accepted == 0 {
+		return accepted, rejected, nil
+	}
+	return accepted, rejected, u.write(records)
+}
+
+//record returns the DB record for fc, as Generator would write it
+//the checksum the check calculated is kept if it is the one the policy records, the file is only hashed otherwise
+func (u *Updater) record(fc *FileCheckInfo) *FileCheckInfo {
+	rec := *fc
+	rec.PrefixDigest, rec.PrefixLen = nil, 0
+	rec.Attrs = u.policy.Attrs(rec.Path, rec.Mode)
+	hashed := len(fc.Digest) == rec.Attrs.newHash().Size() && (rec.Attrs&AttrEntropy == 0 || fc.Attrs&AttrEntropy != 0)
+	if rec.Attrs&(AttrContent|AttrEntropy) != 0 && hashed {
+		if rec.Attrs&AttrGrowing != 0 {
+			if err := rec.CalcPrefixDigest(rec.Size); err != nil {
+				log.Printf("Trouble calculating prefix digest %s: %s\n", rec.Path, err)
+			}
+		}
+		return &rec
+	}
+	rec.Digest, rec.Entropy = nil, 0
+	recordContent(&rec, nil)
+	return &rec
+}
+
+//write copies the DB replacing, dropping and adding entries as records say
+func (u *Updater) write(records map[string]*FileCheckInfo) error {
+	return rewriteDB(u.dbfile, true, records, u.run.Roots, u.label, u.history)
+}
+
+//rewriteDB writes the DB in dbfile anew with records, path -> new record or nil to drop the entry
+//if keep is set the entries of the current DB are copied, replaced or dropped as records say
+//roots are added to those of the current DB, the current DB is archived in h (if not nil) before being replaced
+func rewriteDB(dbfile string, keep bool, records map[string]*FileCheckInfo, roots []string, label string, h *History) error {
+	var r *DBReader
+	roots = append([]string{}, roots...)
+	if keep {
+		r = NewDBReader(dbfile)
+		if err := r.Start(); err != nil {
+			return err
+		}
+		if hdr := r.Header(); hdr != nil {
+			roots = append(roots, hdr.Roots...)
+		}
+	}
+	w := NewDBWriter(dbfile)
+	if err := w.Start(); err != nil {
+		if r != nil {
+			r.Stop()
+		}
+		return err
+	}
+	err := w.PutHeader(&DBHeader{Roots: NewPathPrefixes(roots...).Outermost(), Created: time.Now(), Label: label})
+	done := make(StringSet)
+	if err == nil && r != nil {
+		err = r.mapAll(func(fc *FileCheckInfo) error {
+			rec, ok := records[fc.Path]
+			if !ok {
+				return w.Put(fc)
+			}
+			done.Add(fc.Path)
+			if rec == nil {
+				return nil
+			}
+			return w.Put(rec)
+		})
+	}
+	paths := make([]string, 0, len(records))
+	for path, rec := range records {
+		if rec != nil && !done.Has(path) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err == nil {
+			err = w.Put(records[path])
+		}
+	}
+	if r != nil {
+		if serr := r.Stop(); err == nil {
+			err = serr
+		}
+	}
+	if err == nil && h != nil {
+		err = h.Archive()
+	}
+	if err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Stop()
+}
+
+//PromptReviewer asks an operator about each finding, answering y accepts it, n rejects it,
+//a accepts it and all the findings after it and r rejects it and all the findings after it
+type PromptReviewer struct {
+	in     *bufio.Reader
+	out    io.Writer
+	answer string // a or r once given
+}
+
+//NewPromptReviewer returns new PromptReviewer reading answers from in and asking on out
+func NewPromptReviewer(in io.Reader, out io.Writer) *PromptReviewer {
+	return &PromptReviewer{in: bufio.NewReader(in), out: out}
+}
+
+//Review implements Reviewer
+func (pr *PromptReviewer) Review(f *Finding) (bool, error) {
+	if pr.answer != "" {
+		return pr.answer == "a", nil
+	}
+	desc := f.Kind + " " + f.Path
+	if f.From != "" {
+		desc = fmt.Sprintf("%s %s -> %s", f.Kind, f.From, f.Path)
+	}
+	if len(f.Changes) > 0 {
+		desc += " (" + strings.Join(f.Changes, ", ") + ")"
+	}
+	for {
+		fmt.Fprintf(pr.out, "%s %s, accept? [y,n,a,r] ", f.Severity, desc)
+		line, err := pr.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return false, err
+		}
+		switch answer := strings.ToLower(strings.TrimSpace(line)); answer {
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		case "a", "r":
+			pr.answer = answer
+			return answer == "a", nil
+		}
+		fmt.Fprintln(pr.out, "y - accept, n - reject, a - accept this and all remaining, r - reject this and all remaining")
+	}
+}
@@ -0,0 +1,129 @@
+package fcheck
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type UpdateSuite struct {
+	scratch
+}
+
+var _ = Suite(&UpdateSuite{})
+
+func (s *UpdateSuite) SetUpTest(c *C) {
+	s.setUp(c)
+}
+
+//rejectPaths is a Reviewer rejecting the findings for some paths
+type rejectPaths StringSet
+
+func (r rejectPaths) Review(f *Finding) (bool, error) {
+	_, rejected := r[f.Path]
+	return !rejected, nil
+}
+
+func (s *UpdateSuite) TestUpdate(c *C) {
+	s.write(c, "a", "1")
+	s.write(c, "b", "2")
+	s.write(c, "c/d", "some content")
+	s.write(c, "skip/x", "3")
+	s.generate(c)
+	s.write(c, "a", "changed")
+	c.Assert(os.Remove(filepath.Join(s.root, "b")), IsNil)
+	c.Assert(os.Rename(filepath.Join(s.root, "c/d"), filepath.Join(s.root, "f")), IsNil)
+	s.write(c, "e", "new")
+	s.write(c, "skip/x", "changed too")
+	upd := NewUpdater(s.dbName, nil)
+	upd.SetReviewer(rejectPaths{filepath.Join(s.root, "e"): 1})
+	c.Assert(upd.SetKinds(KindChanged, KindNew, KindRemoved, KindMoved), IsNil)
+	c.Assert(upd.SetKinds("unreadable"), NotNil)
+	upd.SetPaths(filepath.Join(s.root, "a"), filepath.Join(s.root, "b"), filepath.Join(s.root, "c"),
+		filepath.Join(s.root, "e"), filepath.Join(s.root, "f"))
+	s.compare(c, func(cm *Comparator) {
+		cm.SetReporter(upd)
+	})
+	accepted, rejected, err := upd.Commit()
+	c.Assert(err, IsNil)
+	//a, b, c (mtime) and c/d -> f accepted, e rejected, root (mtime) and skip/x not selected
+	c.Assert(accepted, Equals, 4)
+	c.Assert(rejected, Equals, 3)
+	cm, _ := s.compare(c)
+	sort.Strings(cm.changedFiles)
+	c.Assert(cm.changedFiles, DeepEquals, []string{s.root, filepath.Join(s.root, "skip/x")})
+	c.Assert(cm.newFiles, DeepEquals, []string{filepath.Join(s.root, "e")})
+	c.Assert(cm.removedFiles, HasLen, 0)
+	c.Assert(cm.movedFiles, HasLen, 0)
+	//accepting everything leaves nothing to report
+	upd = NewUpdater(s.dbName, nil)
+	s.compare(c, func(cm *Comparator) {
+		cm.SetReporter(upd)
+	})
+	accepted, _, err = upd.Commit()
+	c.Assert(err, IsNil)
+	c.Assert(accepted, Equals, 3)
+	cm, _ = s.compare(c)
+	c.Assert(cm.Counts(), DeepEquals, map[string]int{KindNew: 0, KindChanged: 0, KindRemoved: 0, KindMoved: 0, KindCopied: 0, KindUnreadable: 0})
+}
+
+func (s *UpdateSuite) TestUpdateReviewed(c *C) {
+	s.write(c, "a", "1")
+	s.write(c, "b", "2")
+	s.generate(c)
+	s.write(c, "a", "reviewed")
+	s.write(c, "b", "changed")
+	update := func(minSeverity Severity, commit func(upd *Updater)) {
+		upd := NewUpdater(s.dbName, nil)
+		c.Assert(upd.SetKinds(" changed", "new "), IsNil)
+		upd.SetPaths(filepath.Join(s.root, "a"), filepath.Join(s.root, "b"))
+		s.compare(c, func(cm *Comparator) {
+			cm.SetReporter(upd)
+			cm.SetMinSeverity(minSeverity)
+		})
+		commit(upd)
+	}
+	//the content recorded is the one reviewed, a later rewrite is still reported
+	update(SeverityInfo, func(upd *Updater) {
+		s.write(c, "a", "rewritten after the review")
+		accepted, _, err := upd.Commit()
+		c.Assert(err, IsNil)
+		c.Assert(accepted, Equals, 2)
+	})
+	cm, _ := s.compare(c)
+	c.Assert(cm.changedFiles, DeepEquals, []string{filepath.Join(s.root, "a")})
+	//findings left out of the report are taken over as well
+	update(SeverityCritical, func(upd *Updater) {
+		c.Assert(upd.run.Suppressed, Equals, 1)
+		accepted, _, err := upd.Commit()
+		c.Assert(err, IsNil)
+		c.Assert(accepted, Equals, 1)
+	})
+	cm, _ = s.compare(c)
+	c.Assert(cm.changedFiles, HasLen, 0)
+	//files are only recorded for the findings that may be accepted
+	upd := NewUpdater(s.dbName, nil)
+	c.Assert(upd.SetKinds(KindRemoved), IsNil)
+	c.Assert(upd.Finding(&Finding{Kind: KindChanged, Path: filepath.Join(s.root, "a"), New: &FileCheckInfo{Path: filepath.Join(s.root, "a")}}), IsNil)
+	c.Assert(upd.findings, HasLen, 1)
+	c.Assert(upd.records, HasLen, 0)
+}
+
+func (s *UpdateSuite) TestPromptReviewer(c *C) {
+	var out bytes.Buffer
+	pr := NewPromptReviewer(strings.NewReader("y\nwhat\nn\nr\n"), &out)
+	f := &Finding{Kind: KindChanged, Path: "/etc/passwd", Changes: []string{"digest"}, Severity: SeverityHigh}
+	for _, want := range []bool{true, false, false, false} {
+		ok, err := pr.Review(f)
+		c.Assert(err, IsNil)
+		c.Assert(ok, Equals, want)
+	}
+	c.Assert(strings.HasPrefix(out.String(), "high changed /etc/passwd (digest), accept? [y,n,a,r] "), Equals, true)
+	c.Assert(strings.Contains(out.String(), "y - accept"), Equals, true)
+	_, err := NewPromptReviewer(strings.NewReader(""), &out).Review(f)
+	c.Assert(err, NotNil)
+}